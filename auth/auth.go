@@ -31,46 +31,20 @@ func ValidatePermissionAndGetRoleContext(privileges []types.RoleInfo, reqRoles [
 	return &ctx, foundMatchingRole
 }
 
+// ValidatePermissionAndGetPermissionsContext returns true if the permissions allow all of the rules in
+// reqPermissions, along with the contexts they are allowed in. If they are allowed by a permission without a
+// context, nil is returned for the context map when retNilCtxForEmptyCtx is set. reqPermissions is not modified.
+// See EvaluatePermissions for an evaluation that also reports the rules that are not allowed.
 func ValidatePermissionAndGetPermissionsContext(privileges []types.PermissionInfo, reqPermissions types.PermissionInfo,
 	retNilCtxForEmptyCtx bool) (*map[string]types.PermissionInfo, bool) {
 
-	ctx := make(map[string]types.PermissionInfo)
-	for _, permission := range privileges {
-		if reqPermissions.Service == permission.Service {
-			for _, rule := range permission.Rules {
-				for reqRuleIndex, reqRule := range reqPermissions.Rules {
-					if isAuthorized(rule, reqRule) {
-						if len(reqPermissions.Rules) > 1 {
-							reqPermissions.Rules[reqRuleIndex] = reqPermissions.Rules[len(reqPermissions.Rules) - 1]
-							reqPermissions.Rules = reqPermissions.Rules[:len(reqPermissions.Rules) - 1]
-						} else {
-							if strings.TrimSpace(permission.Context) == "" && retNilCtxForEmptyCtx == true {
-								return nil, true
-							} else if strings.TrimSpace(permission.Context) != "" {
-								ctx[strings.TrimSpace(permission.Context)] = permission
-								return &ctx, true
-							}
-						}
-					}
-				}
-			}
-		}
+	eval := EvaluatePermissions(privileges, PermissionRequirement{
+		Service: reqPermissions.Service,
+		Rules:   reqPermissions.Rules,
+		Mode:    AllOf,
+	})
+	if eval.Unrestricted && retNilCtxForEmptyCtx {
+		return nil, true
 	}
-	return &ctx, false
+	return &eval.Contexts, eval.Granted
 }
-
-func isAuthorized(rule string, reqPermission string) bool {
-	splitRule := strings.Split(rule, ":")
-	splitReqPermission := strings.Split(reqPermission, ":")
-	if len(splitRule) < 2 {
-		return false
-	}
-	if len(splitRule) > 2 && splitRule[2] != "*" {
-		return false
-	}
-	if (splitRule[0] == "*" || splitRule[0] == splitReqPermission[0]) && (splitRule[1] == "*" || splitRule[1] == splitReqPermission[1]) {
-		return true
-	} else {
-		return false
-	}
-}
\ No newline at end of file
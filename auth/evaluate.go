@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	types "intel/isecl/lib/common/v2/types/aas"
+	"strings"
+)
+
+// RequirementMode says whether all or any of the rules of a PermissionRequirement have to be allowed
+type RequirementMode int
+
+const (
+	AllOf RequirementMode = iota
+	AnyOf
+)
+
+func (m RequirementMode) String() string {
+	if m == AnyOf {
+		return "any-of"
+	}
+	return "all-of"
+}
+
+// PermissionRequirement is the set of rules a request needs for a service
+type PermissionRequirement struct {
+	Service string
+	Rules   []string
+	Mode    RequirementMode
+}
+
+// PermissionEvaluation is the result of EvaluatePermissions
+type PermissionEvaluation struct {
+	// Granted is true if the requirement is met without restriction or in at least one context
+	Granted bool
+	// Unrestricted is true if the requirement is met by permissions that are not restricted to a context
+	Unrestricted bool
+	// Contexts holds every context the requirement is met in, with the permissions of the context combined
+	Contexts map[string]types.PermissionInfo
+	// Satisfied holds the required rules that are allowed in at least one context, Unsatisfied the rest
+	Satisfied   []string
+	Unsatisfied []string
+	// Denied holds the required rules that are refused by a deny rule. These are also in Unsatisfied
+	Denied []string
+}
+
+// permissionGroup is the combined rules of all the permissions of a service with the same context
+type permissionGroup struct {
+	permission types.PermissionInfo
+	rules      []*Rule
+}
+
+func (g *permissionGroup) allows(resource, action string, attributes map[string]string) bool {
+	for _, r := range g.rules {
+		if r.Matches(resource, action, attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluatePermissions checks the requirement against the permissions of a caller. Unlike
+// ValidatePermissionAndGetPermissionsContext it never modifies its arguments and it reports every context the
+// requirement is met in, along with the required rules that are not allowed.
+//
+// Permissions without a context apply in every context, so a requirement can be met by combining them with the
+// permissions of a context. Deny rules of the service refuse the rules they match in all contexts. Malformed
+// rules are ignored and a requirement without rules is never granted.
+func EvaluatePermissions(privileges []types.PermissionInfo, req PermissionRequirement) PermissionEvaluation {
+	eval := PermissionEvaluation{Contexts: make(map[string]types.PermissionInfo)}
+
+	// compile the permissions of the service into the unrestricted group and one group per context
+	unrestricted := &permissionGroup{permission: types.PermissionInfo{Service: req.Service}}
+	groups := make(map[string]*permissionGroup)
+	var contextOrder []string
+	var denyRules []*Rule
+	for _, permission := range privileges {
+		if permission.Service != req.Service {
+			continue
+		}
+		group := unrestricted
+		if permCtx := strings.TrimSpace(permission.Context); permCtx != "" {
+			if group = groups[permCtx]; group == nil {
+				group = &permissionGroup{permission: types.PermissionInfo{Service: req.Service, Context: permCtx}}
+				groups[permCtx] = group
+				contextOrder = append(contextOrder, permCtx)
+			}
+		}
+		group.permission.Rules = append(group.permission.Rules, permission.Rules...)
+		for _, rule := range permission.Rules {
+			r, err := ParseRule(rule)
+			if err != nil {
+				continue
+			}
+			if r.Deny {
+				denyRules = append(denyRules, r)
+			} else {
+				group.rules = append(group.rules, r)
+			}
+		}
+	}
+
+	type request struct {
+		rule             string
+		resource, action string
+		attributes       map[string]string
+		denied           bool
+	}
+	requests := make([]request, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		resource, action, attributes := ParseRequestedPermission(rule)
+		rq := request{rule: rule, resource: resource, action: action, attributes: attributes}
+		for _, r := range denyRules {
+			if r.Matches(resource, action, attributes) {
+				rq.denied = true
+				break
+			}
+		}
+		requests = append(requests, rq)
+	}
+
+	// met reports whether the groups together meet the requirement and marks the rules they allow
+	satisfied := make([]bool, len(requests))
+	met := func(groups ...*permissionGroup) bool {
+		allowedCount := 0
+		for i, rq := range requests {
+			if rq.denied {
+				continue
+			}
+			for _, g := range groups {
+				if g.allows(rq.resource, rq.action, rq.attributes) {
+					satisfied[i] = true
+					allowedCount++
+					break
+				}
+			}
+		}
+		if len(requests) == 0 {
+			return false
+		}
+		if req.Mode == AnyOf {
+			return allowedCount > 0
+		}
+		return allowedCount == len(requests)
+	}
+
+	eval.Unrestricted = met(unrestricted)
+	for _, permCtx := range contextOrder {
+		if met(unrestricted, groups[permCtx]) {
+			eval.Contexts[permCtx] = groups[permCtx].permission
+		}
+	}
+	eval.Granted = eval.Unrestricted || len(eval.Contexts) > 0
+
+	for i, rq := range requests {
+		if satisfied[i] {
+			eval.Satisfied = append(eval.Satisfied, rq.rule)
+			continue
+		}
+		eval.Unsatisfied = append(eval.Unsatisfied, rq.rule)
+		if rq.denied {
+			eval.Denied = append(eval.Denied, rq.rule)
+		}
+	}
+	return eval
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	types "intel/isecl/lib/common/v2/types/aas"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluatePermissions(t *testing.T) {
+	privileges := []types.PermissionInfo{
+		{Service: "HVS", Rules: []string{"hosts:search"}},
+		{Service: "HVS", Context: "tenant=acme", Rules: []string{"hosts:retrieve", "hosts:delete"}},
+		{Service: "HVS", Context: "tenant=coyote", Rules: []string{"hosts:retrieve"}},
+		{Service: "HVS", Context: "tenant=coyote", Rules: []string{"flavors:*"}},
+		{Service: "HVS", Context: "tenant=roadrunner", Rules: []string{"!flavors:delete"}},
+		{Service: "WLS", Rules: []string{"*:*"}},
+	}
+
+	tests := []struct {
+		name         string
+		rules        []string
+		mode         RequirementMode
+		granted      bool
+		unrestricted bool
+		contexts     []string
+		unsatisfied  []string
+		denied       []string
+	}{
+		{
+			name: "unrestricted", rules: []string{"hosts:search"},
+			granted: true, unrestricted: true, contexts: []string{"tenant=acme", "tenant=coyote", "tenant=roadrunner"},
+		},
+		{
+			name: "every matching context", rules: []string{"hosts:retrieve"},
+			granted: true, contexts: []string{"tenant=acme", "tenant=coyote"},
+		},
+		{
+			name: "all-of combines unrestricted permissions with a context", rules: []string{"hosts:search", "hosts:delete"},
+			granted: true, contexts: []string{"tenant=acme"},
+		},
+		{
+			name: "all-of needs every rule in the same context", rules: []string{"hosts:delete", "flavors:search"},
+			granted: false,
+		},
+		{
+			name: "any-of", rules: []string{"hosts:delete", "flavors:search", "reports:search"}, mode: AnyOf,
+			granted: true, contexts: []string{"tenant=acme", "tenant=coyote"}, unsatisfied: []string{"reports:search"},
+		},
+		{
+			name: "not allowed anywhere", rules: []string{"hosts:search", "reports:search"},
+			granted: false, unsatisfied: []string{"reports:search"},
+		},
+		{
+			name: "deny rules apply in all contexts", rules: []string{"flavors:delete"},
+			granted: false, unsatisfied: []string{"flavors:delete"}, denied: []string{"flavors:delete"},
+		},
+		{
+			name: "no rules", rules: nil, granted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		rules := append([]string(nil), tt.rules...)
+		eval := EvaluatePermissions(privileges, PermissionRequirement{Service: "HVS", Rules: rules, Mode: tt.mode})
+
+		assert.Equal(t, tt.rules, rules, tt.name)
+		assert.Equal(t, tt.granted, eval.Granted, tt.name)
+		assert.Equal(t, tt.unrestricted, eval.Unrestricted, tt.name)
+		var contexts []string
+		for permCtx := range eval.Contexts {
+			contexts = append(contexts, permCtx)
+		}
+		assert.ElementsMatch(t, tt.contexts, contexts, tt.name)
+		assert.Equal(t, tt.unsatisfied, eval.Unsatisfied, tt.name)
+		assert.Equal(t, tt.denied, eval.Denied, tt.name)
+	}
+}
+
+func TestEvaluatePermissionsCombinesContexts(t *testing.T) {
+	privileges := []types.PermissionInfo{
+		{Service: "HVS", Context: "tenant=coyote", Rules: []string{"hosts:retrieve"}},
+		{Service: "HVS", Context: " tenant=coyote ", Rules: []string{"flavors:*"}},
+	}
+	eval := EvaluatePermissions(privileges, PermissionRequirement{Service: "HVS", Rules: []string{"hosts:retrieve", "flavors:search"}})
+	assert.True(t, eval.Granted)
+	assert.Equal(t, []string{"hosts:retrieve", "flavors:*"}, eval.Contexts["tenant=coyote"].Rules)
+	assert.Equal(t, []string{"hosts:retrieve", "flavors:search"}, eval.Satisfied)
+}
+
+func TestValidatePermissionAndGetPermissionsContextDoesNotModifyRules(t *testing.T) {
+	privileges := []types.PermissionInfo{
+		{Service: "HVS", Context: "tenant=acme", Rules: []string{"hosts:*"}},
+		{Service: "HVS", Context: "tenant=coyote", Rules: []string{"hosts:*"}},
+	}
+	reqRules := []string{"hosts:search", "hosts:retrieve"}
+	ctx, found := ValidatePermissionAndGetPermissionsContext(privileges, types.PermissionInfo{Service: "HVS", Rules: reqRules}, true)
+	assert.True(t, found)
+	assert.Len(t, *ctx, 2)
+	assert.Equal(t, []string{"hosts:search", "hosts:retrieve"}, reqRules)
+
+	ctx, found = ValidatePermissionAndGetPermissionsContext([]types.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}},
+		types.PermissionInfo{Service: "HVS", Rules: reqRules}, true)
+	assert.True(t, found)
+	assert.Nil(t, ctx)
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	types "intel/isecl/lib/common/v2/types/aas"
+)
+
+// decisions reported in a Trace
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// results of a rule in a RuleTrace
+const (
+	RuleAllowed   = "allowed"
+	RuleDenied    = "denied"
+	RuleNoMatch   = "no-match"
+	RuleMalformed = "malformed"
+)
+
+// RuleTrace is the result of checking one rule of a permission against one requested rule
+type RuleTrace struct {
+	Rule      string `json:"rule"`
+	Requested string `json:"requested"`
+	Result    string `json:"result"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// PermissionTrace lists the rules checked for one PermissionInfo of the caller
+type PermissionTrace struct {
+	Permission types.PermissionInfo `json:"permission"`
+	Skipped    bool                 `json:"skipped,omitempty"`
+	Reason     string               `json:"reason,omitempty"`
+	Rules      []RuleTrace          `json:"rules,omitempty"`
+}
+
+// RoleTrace is the result of checking one RoleInfo of the caller against the required roles
+type RoleTrace struct {
+	Role    types.RoleInfo `json:"role"`
+	Matched bool           `json:"matched"`
+	Reason  string         `json:"reason"`
+}
+
+// Trace explains an authorization decision. It records every role or permission of the caller that was examined,
+// the outcome for each of their rules and the final decision
+type Trace struct {
+	Service       string            `json:"service,omitempty"`
+	Mode          string            `json:"mode,omitempty"`
+	Required      []string          `json:"required_rules,omitempty"`
+	RequiredRoles []types.RoleInfo  `json:"required_roles,omitempty"`
+	Permissions   []PermissionTrace `json:"permissions,omitempty"`
+	Roles         []RoleTrace       `json:"roles,omitempty"`
+	Contexts      []string          `json:"contexts,omitempty"`
+	Unsatisfied   []string          `json:"unsatisfied_rules,omitempty"`
+	Denied        []string          `json:"denied_rules,omitempty"`
+	Decision      string            `json:"decision"`
+	Reason        string            `json:"reason"`
+}
+
+// ExplainPermissions evaluates the requirement like EvaluatePermissions and returns a trace of how the decision
+// was made. It is meant for troubleshooting, requests should be authorized with EvaluatePermissions.
+func ExplainPermissions(privileges []types.PermissionInfo, req PermissionRequirement) *Trace {
+	trace := &Trace{
+		Service:  req.Service,
+		Mode:     req.Mode.String(),
+		Required: req.Rules,
+	}
+
+	for _, permission := range privileges {
+		pt := PermissionTrace{Permission: permission}
+		if permission.Service != req.Service {
+			pt.Skipped = true
+			pt.Reason = fmt.Sprintf("permission is for service %q", permission.Service)
+			trace.Permissions = append(trace.Permissions, pt)
+			continue
+		}
+		for _, rule := range permission.Rules {
+			r, err := ParseRule(rule)
+			for _, reqRule := range req.Rules {
+				rt := RuleTrace{Rule: rule, Requested: reqRule}
+				switch {
+				case err != nil:
+					rt.Result = RuleMalformed
+					rt.Reason = err.Error()
+				default:
+					if rt.Reason = r.mismatch(ParseRequestedPermission(reqRule)); rt.Reason != "" {
+						rt.Result = RuleNoMatch
+					} else if r.Deny {
+						rt.Result = RuleDenied
+						rt.Reason = "deny rule matches the request"
+					} else {
+						rt.Result = RuleAllowed
+					}
+				}
+				pt.Rules = append(pt.Rules, rt)
+			}
+		}
+		trace.Permissions = append(trace.Permissions, pt)
+	}
+
+	eval := EvaluatePermissions(privileges, req)
+	for permCtx := range eval.Contexts {
+		trace.Contexts = append(trace.Contexts, permCtx)
+	}
+	sort.Strings(trace.Contexts)
+	trace.Unsatisfied = eval.Unsatisfied
+	trace.Denied = eval.Denied
+
+	switch {
+	case len(req.Rules) == 0:
+		trace.Decision, trace.Reason = DecisionDeny, "no rules are required, which is never granted"
+	case eval.Unrestricted:
+		trace.Decision, trace.Reason = DecisionAllow, fmt.Sprintf("%s of the rules are allowed by permissions without a context", req.Mode)
+	case eval.Granted:
+		trace.Decision, trace.Reason = DecisionAllow, fmt.Sprintf("%s of the rules are allowed in the contexts %v", req.Mode, trace.Contexts)
+	case len(eval.Denied) > 0:
+		trace.Decision, trace.Reason = DecisionDeny, fmt.Sprintf("rules %v are refused by deny rules", eval.Denied)
+	case len(eval.Unsatisfied) > 0:
+		trace.Decision, trace.Reason = DecisionDeny, fmt.Sprintf("rules %v are not allowed by any permission", eval.Unsatisfied)
+	default:
+		trace.Decision, trace.Reason = DecisionDeny, "the required rules are not all allowed in the same context"
+	}
+	return trace
+}
+
+// ExplainRoles checks the roles of the caller against the required roles like
+// ValidatePermissionAndGetRoleContext and returns a trace of how the decision was made
+func ExplainRoles(privileges []types.RoleInfo, reqRoles []types.RoleInfo) *Trace {
+	trace := &Trace{RequiredRoles: reqRoles, Decision: DecisionDeny, Reason: "caller has none of the required roles"}
+	for _, role := range privileges {
+		rt := RoleTrace{Role: role, Reason: "role is not one of the required roles"}
+		for _, reqRole := range reqRoles {
+			if role.Service == reqRole.Service && role.Name == reqRole.Name {
+				rt.Matched = true
+				rt.Reason = fmt.Sprintf("matches required role %s:%s", reqRole.Service, reqRole.Name)
+				if roleCtx := strings.TrimSpace(role.Context); roleCtx != "" {
+					trace.Contexts = append(trace.Contexts, roleCtx)
+					rt.Reason += " in context " + roleCtx
+				}
+				trace.Decision = DecisionAllow
+				trace.Reason = "caller has at least one of the required roles"
+				break
+			}
+		}
+		trace.Roles = append(trace.Roles, rt)
+	}
+	return trace
+}
+
+// Print writes the trace in a form that can be read by a person
+func (t *Trace) Print(w io.Writer) {
+	fmt.Fprintf(w, "decision: %s\n", t.Decision)
+	fmt.Fprintf(w, "reason:   %s\n", t.Reason)
+	if len(t.Required) > 0 {
+		fmt.Fprintf(w, "required: %s of %s for service %s\n", t.Mode, strings.Join(t.Required, ", "), t.Service)
+	}
+	for _, role := range t.RequiredRoles {
+		fmt.Fprintf(w, "required: role %s:%s\n", role.Service, role.Name)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, pt := range t.Permissions {
+		fmt.Fprintf(tw, "\npermission %s context=%q\n", pt.Permission.Service, pt.Permission.Context)
+		if pt.Skipped {
+			fmt.Fprintf(tw, "    skipped: %s\n", pt.Reason)
+			continue
+		}
+		for _, rt := range pt.Rules {
+			fmt.Fprintf(tw, "    %s\t%s\t%s\t%s\n", rt.Rule, rt.Requested, rt.Result, rt.Reason)
+		}
+	}
+	if len(t.Roles) > 0 {
+		fmt.Fprintln(tw, "\nroles")
+	}
+	for _, rt := range t.Roles {
+		fmt.Fprintf(tw, "    %s:%s\tcontext=%q\t%t\t%s\n", rt.Role.Service, rt.Role.Name, rt.Role.Context, rt.Matched, rt.Reason)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+// Package explain provides the authz-explain command line, which explains authorization decisions of package auth
+// for the privileges in a token
+package explain
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"intel/isecl/lib/common/v2/auth"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	types "intel/isecl/lib/common/v2/types/aas"
+)
+
+// stringList collects the values of a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// RunCmd explains why the privileges in a token are or are not enough for a request. It is meant to be
+// wired up as a sub command of a service so that support engineers can troubleshoot 403 responses. The flags are
+//
+//	--token <jwt> | --token-file <path>    token of the caller
+//	--service <name> --rule <rule> ...     required permission rules, --any-of if only one of them is needed
+//	--role <service>:<name> ...            required roles
+//	--json                                 print the trace as json
+//
+// The signature of the token is not verified, the token is only used for the privileges it carries.
+func RunCmd(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("authz-explain", flag.ContinueOnError)
+	fs.SetOutput(w)
+	token := fs.String("token", "", "bearer token of the caller")
+	tokenFile := fs.String("token-file", "", "file containing the bearer token of the caller")
+	service := fs.String("service", "", "service the permission rules are required for")
+	anyOf := fs.Bool("any-of", false, "only one of the rules is required")
+	printJson := fs.Bool("json", false, "print the trace as json")
+	var rules, roles stringList
+	fs.Var(&rules, "rule", "required permission rule, can be repeated")
+	fs.Var(&roles, "role", "required role as service:name, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *tokenFile != "" {
+		tokenBytes, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read token file: %v", err)
+		}
+		*token = string(tokenBytes)
+	}
+	if strings.TrimSpace(*token) == "" {
+		return fmt.Errorf("a token has to be provided with --token or --token-file")
+	}
+	if len(rules) == 0 && len(roles) == 0 {
+		return fmt.Errorf("at least one --rule or --role has to be provided")
+	}
+	if len(rules) > 0 && *service == "" {
+		return fmt.Errorf("--service has to be provided along with --rule")
+	}
+
+	claims := types.AuthClaims{}
+	if _, err := jwtauth.ParseTokenUnverified(strings.TrimSpace(*token), &claims); err != nil {
+		return fmt.Errorf("could not parse token: %v", err)
+	}
+
+	var traces []*auth.Trace
+	if len(rules) > 0 {
+		req := auth.PermissionRequirement{Service: *service, Rules: rules}
+		if *anyOf {
+			req.Mode = auth.AnyOf
+		}
+		traces = append(traces, auth.ExplainPermissions(claims.Permissions, req))
+	}
+	if len(roles) > 0 {
+		var reqRoles []types.RoleInfo
+		for _, role := range roles {
+			parts := strings.SplitN(role, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("role %q should have the form service:name", role)
+			}
+			reqRoles = append(reqRoles, types.RoleInfo{Service: parts[0], Name: parts[1]})
+		}
+		traces = append(traces, auth.ExplainRoles(claims.Roles, reqRoles))
+	}
+
+	for i, trace := range traces {
+		if *printJson {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(trace); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		trace.Print(w)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package explain
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/lib/common/v2/auth"
+	"intel/isecl/lib/common/v2/crypt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCmd(t *testing.T) {
+	_, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("jwt signing", "", "ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory, err := jwtauth.NewTokenFactory(pkcs8Der, false, nil, "AAS JWT Issuer", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := factory.Create(&types.AuthClaims{
+		Roles:       []types.RoleInfo{{Service: "HVS", Name: "Administrator"}},
+		Permissions: []types.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}},
+	}, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, RunCmd([]string{"--token", token, "--service", "HVS", "--rule", "hosts:search", "--rule", "flavors:search"}, &out))
+	assert.Contains(t, out.String(), "decision: deny")
+	assert.Contains(t, out.String(), "flavors:search")
+
+	out.Reset()
+	assert.NoError(t, RunCmd([]string{"--token", token, "--role", "HVS:Administrator", "--json"}, &out))
+	trace := auth.Trace{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &trace))
+	assert.Equal(t, auth.DecisionAllow, trace.Decision)
+
+	assert.Error(t, RunCmd([]string{"--token", token}, &out))
+	assert.Error(t, RunCmd([]string{"--rule", "hosts:search", "--service", "HVS"}, &out))
+	assert.Error(t, RunCmd([]string{"--token", token, "--rule", "hosts:search"}, &out))
+}
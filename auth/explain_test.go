@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	types "intel/isecl/lib/common/v2/types/aas"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainPermissions(t *testing.T) {
+	privileges := []types.PermissionInfo{
+		{Service: "WLS", Rules: []string{"*:*"}},
+		{Service: "HVS", Rules: []string{"hosts:search", "hosts"}},
+		{Service: "HVS", Context: "tenant=acme", Rules: []string{"!hosts:delete"}},
+	}
+
+	trace := ExplainPermissions(privileges, PermissionRequirement{Service: "HVS", Rules: []string{"hosts:search", "hosts:delete"}})
+	assert.Equal(t, DecisionDeny, trace.Decision)
+	assert.Contains(t, trace.Reason, "deny rules")
+	assert.Equal(t, []string{"hosts:delete"}, trace.Denied)
+	assert.Len(t, trace.Permissions, 3)
+
+	assert.True(t, trace.Permissions[0].Skipped)
+	assert.Equal(t, []RuleTrace{
+		{Rule: "hosts:search", Requested: "hosts:search", Result: RuleAllowed},
+		{Rule: "hosts:search", Requested: "hosts:delete", Result: RuleNoMatch, Reason: `action "delete" does not match "search"`},
+	}, trace.Permissions[1].Rules[:2])
+	assert.Equal(t, RuleMalformed, trace.Permissions[1].Rules[2].Result)
+	assert.Equal(t, RuleDenied, trace.Permissions[2].Rules[1].Result)
+
+	trace = ExplainPermissions(privileges, PermissionRequirement{Service: "HVS", Rules: []string{"hosts:search"}})
+	assert.Equal(t, DecisionAllow, trace.Decision)
+
+	trace = ExplainPermissions(privileges, PermissionRequirement{Service: "HVS", Rules: []string{"flavors:search:tenant=acme"}})
+	assert.Equal(t, DecisionDeny, trace.Decision)
+	assert.Equal(t, []string{"flavors:search:tenant=acme"}, trace.Unsatisfied)
+}
+
+func TestExplainRoles(t *testing.T) {
+	privileges := []types.RoleInfo{{Service: "HVS", Name: "Administrator", Context: "tenant=acme"}, {Service: "WLS", Name: "Administrator"}}
+
+	trace := ExplainRoles(privileges, []types.RoleInfo{{Service: "HVS", Name: "Administrator"}})
+	assert.Equal(t, DecisionAllow, trace.Decision)
+	assert.Equal(t, []string{"tenant=acme"}, trace.Contexts)
+	assert.True(t, trace.Roles[0].Matched)
+	assert.False(t, trace.Roles[1].Matched)
+
+	trace = ExplainRoles(privileges, []types.RoleInfo{{Service: "HVS", Name: "HostManager"}})
+	assert.Equal(t, DecisionDeny, trace.Decision)
+}
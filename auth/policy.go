@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"intel/isecl/lib/common/v2/serialize"
+	types "intel/isecl/lib/common/v2/types/aas"
+)
+
+// Policy is a declarative RBAC policy. It lists the services that are known and the permission rules each role
+// grants, by service. A policy file looks like
+//
+//	services:
+//	  - HVS
+//	  - WLS
+//	roles:
+//	  - service: HVS
+//	    name: HostManager
+//	    permissions:
+//	      HVS: ["hosts:*", "!hosts:delete"]
+//	      WLS: ["reports:search"]
+type Policy struct {
+	Services []string     `yaml:"services" json:"services"`
+	Roles    []PolicyRole `yaml:"roles" json:"roles"`
+}
+
+// PolicyRole is a role and the rules it grants for each service
+type PolicyRole struct {
+	Service     string              `yaml:"service" json:"service"`
+	Name        string              `yaml:"name" json:"name"`
+	Permissions map[string][]string `yaml:"permissions" json:"permissions"`
+}
+
+// PolicyValidationError lists all the problems found in a policy
+type PolicyValidationError struct {
+	Problems []string
+}
+
+func (e PolicyValidationError) Error() string {
+	return fmt.Sprintf("invalid rbac policy: %s", strings.Join(e.Problems, "; "))
+}
+
+// LoadPolicy loads a yaml policy file and validates it
+func LoadPolicy(path string) (*Policy, error) {
+	policy := Policy{}
+	if err := serialize.LoadFromYamlFile(path, &policy); err != nil {
+		return nil, fmt.Errorf("could not load rbac policy from %s: %v", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate flags roles without a service or name, duplicate roles, services that are not listed in Services and
+// rules that cannot be parsed. All problems are returned together in a *PolicyValidationError.
+func (p *Policy) Validate() error {
+	var problems []string
+	services := make(map[string]bool)
+	for _, service := range p.Services {
+		services[service] = true
+	}
+	roles := make(map[string]bool)
+
+	for i, role := range p.Roles {
+		if role.Service == "" || role.Name == "" {
+			problems = append(problems, fmt.Sprintf("role %d has no service or name", i))
+			continue
+		}
+		roleName := role.Service + ":" + role.Name
+		if roles[roleName] {
+			problems = append(problems, fmt.Sprintf("role %s is defined more than once", roleName))
+		}
+		roles[roleName] = true
+		if !services[role.Service] {
+			problems = append(problems, fmt.Sprintf("role %s belongs to unknown service %s", roleName, role.Service))
+		}
+
+		for _, service := range sortedServices(role.Permissions) {
+			if !services[service] {
+				problems = append(problems, fmt.Sprintf("role %s grants permissions for unknown service %s", roleName, service))
+			}
+			for _, rule := range role.Permissions[service] {
+				if _, err := ParseRule(rule); err != nil {
+					problems = append(problems, fmt.Sprintf("role %s: %v", roleName, err))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &PolicyValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func sortedServices(permissions map[string][]string) []string {
+	services := make([]string, 0, len(permissions))
+	for service := range permissions {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// PolicyResolver expands roles into the permissions a policy grants them
+type PolicyResolver struct {
+	roles map[string]*PolicyRole
+}
+
+// NewPolicyResolver validates the policy and indexes its roles
+func NewPolicyResolver(p *Policy) (*PolicyResolver, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	r := &PolicyResolver{roles: make(map[string]*PolicyRole)}
+	for i := range p.Roles {
+		r.roles[p.Roles[i].Service+":"+p.Roles[i].Name] = &p.Roles[i]
+	}
+	return r, nil
+}
+
+// Resolve returns the effective permissions of the roles, e.g. the roles in a token. The context of a role is
+// carried over to the permissions it grants. Permissions with the same service and context are combined and
+// roles that are not in the policy grant nothing.
+func (r *PolicyResolver) Resolve(roles []types.RoleInfo) []types.PermissionInfo {
+	var permissions []types.PermissionInfo
+	index := make(map[string]int)
+	seenRules := make(map[string]bool)
+
+	for _, role := range roles {
+		policyRole, found := r.roles[role.Service+":"+role.Name]
+		if !found {
+			continue
+		}
+		roleCtx := strings.TrimSpace(role.Context)
+		for _, service := range sortedServices(policyRole.Permissions) {
+			key := service + "\x00" + roleCtx
+			i, found := index[key]
+			if !found {
+				i = len(permissions)
+				index[key] = i
+				permissions = append(permissions, types.PermissionInfo{Service: service, Context: roleCtx, Rules: []string{}})
+			}
+			for _, rule := range policyRole.Permissions[service] {
+				if seenRules[key+"\x00"+rule] {
+					continue
+				}
+				seenRules[key+"\x00"+rule] = true
+				permissions[i].Rules = append(permissions[i].Rules, rule)
+			}
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	types "intel/isecl/lib/common/v2/types/aas"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+services:
+  - HVS
+  - WLS
+roles:
+  - service: HVS
+    name: Administrator
+    permissions:
+      HVS: ["*:*"]
+  - service: HVS
+    name: HostManager
+    permissions:
+      HVS: ["hosts:*", "!hosts:delete"]
+      WLS: ["reports:search"]
+  - service: WLS
+    name: ReportReader
+    permissions:
+      WLS: ["reports:search", "reports:retrieve"]
+`
+
+func writePolicy(t *testing.T, policy string) string {
+	f, err := ioutil.TempFile("", "rbac-policy-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString(policy)
+	return f.Name()
+}
+
+func TestLoadPolicyAndResolve(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	defer os.Remove(path)
+
+	policy, err := LoadPolicy(path)
+	assert.NoError(t, err)
+	assert.Len(t, policy.Roles, 3)
+
+	resolver, err := NewPolicyResolver(policy)
+	assert.NoError(t, err)
+
+	permissions := resolver.Resolve([]types.RoleInfo{
+		{Service: "HVS", Name: "HostManager", Context: "tenant=acme"},
+		{Service: "WLS", Name: "ReportReader", Context: "tenant=acme"},
+		{Service: "HVS", Name: "Unknown"},
+	})
+	assert.Equal(t, []types.PermissionInfo{
+		{Service: "HVS", Context: "tenant=acme", Rules: []string{"hosts:*", "!hosts:delete"}},
+		{Service: "WLS", Context: "tenant=acme", Rules: []string{"reports:search", "reports:retrieve"}},
+	}, permissions)
+
+	// the resolved permissions can be evaluated directly
+	eval := EvaluatePermissions(permissions, PermissionRequirement{Service: "HVS", Rules: []string{"hosts:delete"}})
+	assert.False(t, eval.Granted)
+
+	assert.Empty(t, resolver.Resolve(nil))
+}
+
+func TestPolicyValidate(t *testing.T) {
+	policy := Policy{
+		Services: []string{"HVS"},
+		Roles: []PolicyRole{
+			{Service: "HVS", Name: "Administrator", Permissions: map[string][]string{"HVS": {"*:*"}}},
+			{Service: "HVS", Name: "Administrator", Permissions: map[string][]string{"HVS": {"*:*"}}},
+			{Service: "KBS", Name: "KeyManager", Permissions: map[string][]string{"KBS": {"keys:*"}}},
+			{Service: "HVS", Name: "HostManager", Permissions: map[string][]string{"HVS": {"hosts"}}},
+			{Name: "NoService"},
+		},
+	}
+	err := policy.Validate()
+	if assert.Error(t, err) {
+		problems := err.(*PolicyValidationError).Problems
+		assert.Len(t, problems, 5)
+		assert.Contains(t, problems, "role HVS:Administrator is defined more than once")
+		assert.Contains(t, problems, "role KBS:KeyManager belongs to unknown service KBS")
+		assert.Contains(t, problems, "role KBS:KeyManager grants permissions for unknown service KBS")
+		assert.Contains(t, problems, "role 4 has no service or name")
+	}
+	_, err = NewPolicyResolver(&policy)
+	assert.Error(t, err)
+
+	path := writePolicy(t, "services: [HVS]\nroles:\n  - service: HVS\n    nam: Administrator\n")
+	defer os.Remove(path)
+	_, err = LoadPolicy(path)
+	assert.Error(t, err)
+}
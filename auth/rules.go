@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	"fmt"
+	"intel/isecl/lib/common/v2/search"
+	"strings"
+)
+
+// glob is a precompiled segment pattern. Literals and "*" are matched without running the wildcard matcher.
+type glob struct {
+	pattern string
+	literal bool
+	any     bool
+}
+
+func newGlob(pattern string) glob {
+	return glob{
+		pattern: pattern,
+		literal: !strings.ContainsAny(pattern, "*?"),
+		any:     pattern == "*",
+	}
+}
+
+func (g glob) match(s string) bool {
+	switch {
+	case g.any:
+		return true
+	case g.literal:
+		return s == g.pattern
+	default:
+		return search.WildcardMatched(s, g.pattern)
+	}
+}
+
+type selectorTerm struct {
+	key     string
+	pattern glob
+}
+
+// Rule is a parsed permission rule
+type Rule struct {
+	Deny     bool
+	raw      string
+	resource glob
+	action   glob
+	selector []selectorTerm
+}
+
+// ParseRule parses a permission rule. Rules have the form
+//
+//	[!]resource:action[:selector]
+//
+// The resource and action are glob patterns matched with search.WildcardMatched, so "*", "host*" or "re?d" are
+// all valid. A rule prefixed with "!" is a deny rule. When a deny rule matches a requested permission, the
+// permission is not granted regardless of the rules that allow it.
+//
+// The optional selector restricts the rule to requests with matching attributes. It is a comma separated list of
+// key=pattern terms, all of which have to match an attribute of the request. A selector of "*" or no selector
+// matches any request. Attributes can be passed in the third segment of a requested permission in the same
+// key=value form, e.g. "hosts:retrieve:tenant=acme,host_id=1234" is allowed by "hosts:re*:tenant=acme" and by
+// "hosts:*", but not by "hosts:*:tenant=coyote".
+func ParseRule(rule string) (*Rule, error) {
+	raw := strings.TrimSpace(rule)
+	r := &Rule{raw: raw}
+	if strings.HasPrefix(raw, "!") {
+		r.Deny = true
+		raw = strings.TrimSpace(raw[1:])
+	}
+
+	segments := strings.SplitN(raw, ":", 3)
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("permission rule %q should have the form resource:action[:selector]", rule)
+	}
+	if segments[0] == "" || segments[1] == "" {
+		return nil, fmt.Errorf("permission rule %q has an empty resource or action", rule)
+	}
+	r.resource = newGlob(segments[0])
+	r.action = newGlob(segments[1])
+
+	if len(segments) == 3 && segments[2] != "" && segments[2] != "*" {
+		for _, term := range strings.Split(segments[2], ",") {
+			kv := strings.SplitN(term, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, fmt.Errorf("selector term %q in permission rule %q should have the form key=pattern", term, rule)
+			}
+			r.selector = append(r.selector, selectorTerm{strings.TrimSpace(kv[0]), newGlob(strings.TrimSpace(kv[1]))})
+		}
+	}
+	return r, nil
+}
+
+func (r *Rule) String() string {
+	return r.raw
+}
+
+// Matches returns true if the rule applies to the resource and action with the given request attributes. It does
+// not take Deny into account
+func (r *Rule) Matches(resource, action string, attributes map[string]string) bool {
+	if !r.resource.match(resource) || !r.action.match(action) {
+		return false
+	}
+	for _, term := range r.selector {
+		value, found := attributes[term.key]
+		if !found || !term.pattern.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// mismatch is Matches for the explain trace. It returns why the rule does not apply to the request, or an empty
+// string if it does
+func (r *Rule) mismatch(resource, action string, attributes map[string]string) string {
+	if !r.resource.match(resource) {
+		return fmt.Sprintf("resource %q does not match %q", resource, r.resource.pattern)
+	}
+	if !r.action.match(action) {
+		return fmt.Sprintf("action %q does not match %q", action, r.action.pattern)
+	}
+	for _, term := range r.selector {
+		value, found := attributes[term.key]
+		if !found {
+			return fmt.Sprintf("request has no attribute %q required by the selector", term.key)
+		}
+		if !term.pattern.match(value) {
+			return fmt.Sprintf("attribute %s=%q does not match %q", term.key, value, term.pattern.pattern)
+		}
+	}
+	return ""
+}
+
+// ParseRequestedPermission splits a requested permission of the form resource:action[:key=value,...] into the
+// resource, the action and the request attributes
+func ParseRequestedPermission(reqPermission string) (resource, action string, attributes map[string]string) {
+	segments := strings.SplitN(strings.TrimSpace(reqPermission), ":", 3)
+	resource = segments[0]
+	if len(segments) > 1 {
+		action = segments[1]
+	}
+	if len(segments) > 2 {
+		for _, term := range strings.Split(segments[2], ",") {
+			if kv := strings.SplitN(term, "=", 2); len(kv) == 2 {
+				if attributes == nil {
+					attributes = make(map[string]string)
+				}
+				attributes[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	return resource, action, attributes
+}
+
+// RuleSet is a precompiled list of permission rules, for callers that evaluate the same rules many times
+type RuleSet struct {
+	allow []*Rule
+	deny  []*Rule
+}
+
+// CompileRuleSet parses the rules into a RuleSet. It fails on the first rule that cannot be parsed
+func CompileRuleSet(rules ...string) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for _, rule := range rules {
+		r, err := ParseRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if r.Deny {
+			rs.deny = append(rs.deny, r)
+		} else {
+			rs.allow = append(rs.allow, r)
+		}
+	}
+	return rs, nil
+}
+
+// Allows returns true if the requested permission is allowed by at least one rule and not denied by any
+func (rs *RuleSet) Allows(reqPermission string) bool {
+	return rs.AllowsRequest(ParseRequestedPermission(reqPermission))
+}
+
+// AllowsRequest is Allows for a request that has already been split into resource, action and attributes
+func (rs *RuleSet) AllowsRequest(resource, action string, attributes map[string]string) bool {
+	if rs.Denies(resource, action, attributes) {
+		return false
+	}
+	for _, r := range rs.allow {
+		if r.Matches(resource, action, attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denies returns true if a deny rule of the set matches the request
+func (rs *RuleSet) Denies(resource, action string, attributes map[string]string) bool {
+	for _, r := range rs.deny {
+		if r.Matches(resource, action, attributes) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package auth
+
+import (
+	types "intel/isecl/lib/common/v2/types/aas"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule       string
+		valid      bool
+		deny       bool
+		normalized string
+	}{
+		{rule: "hosts:search", valid: true},
+		{rule: " hosts:search ", valid: true, normalized: "hosts:search"},
+		{rule: "hosts:*:*", valid: true},
+		{rule: "host*:re?d", valid: true},
+		{rule: "!hosts:delete", valid: true, deny: true},
+		{rule: "hosts:retrieve:tenant=acme,host_id=12*", valid: true},
+		{rule: "hosts", valid: false},
+		{rule: ":search", valid: false},
+		{rule: "hosts:", valid: false},
+		{rule: "!", valid: false},
+		{rule: "hosts:search:acme", valid: false},
+		{rule: "hosts:search:=acme", valid: false},
+	}
+	for _, tt := range tests {
+		r, err := ParseRule(tt.rule)
+		if !tt.valid {
+			assert.Error(t, err, tt.rule)
+			continue
+		}
+		if assert.NoError(t, err, tt.rule) {
+			assert.Equal(t, tt.deny, r.Deny, tt.rule)
+			if tt.normalized != "" {
+				assert.Equal(t, tt.normalized, r.String())
+			}
+		}
+	}
+}
+
+func TestIsAuthorized(t *testing.T) {
+	tests := []struct {
+		rule       string
+		reqRule    string
+		authorized bool
+	}{
+		// literal segments
+		{"hosts:search", "hosts:search", true},
+		{"hosts:search", "hosts:create", false},
+		{"hosts:search", "flavors:search", false},
+		// wildcard segments
+		{"*:*", "hosts:search", true},
+		{"hosts:*", "hosts:search", true},
+		{"*:search", "flavors:search", true},
+		{"*:search", "flavors:create", false},
+		{"hosts:*:*", "hosts:delete", true},
+		// glob segments
+		{"host*:search", "hosts:search", true},
+		{"host*:search", "host_status:search", true},
+		{"host*:search", "flavors:search", false},
+		{"hosts:re*", "hosts:retrieve", true},
+		{"hosts:re*", "hosts:search", false},
+		{"hosts:re?d", "hosts:read", true},
+		{"hosts:re?d", "hosts:reed", true},
+		{"hosts:re?d", "hosts:retrieve", false},
+		{"host*:re*", "hosts:retrieve", true},
+		// deny rules never authorize on their own
+		{"!hosts:search", "hosts:search", false},
+		// selectors
+		{"hosts:retrieve:tenant=acme", "hosts:retrieve:tenant=acme", true},
+		{"hosts:retrieve:tenant=acme", "hosts:retrieve:tenant=coyote", false},
+		{"hosts:retrieve:tenant=acme", "hosts:retrieve", false},
+		{"hosts:retrieve:tenant=ac*", "hosts:retrieve:tenant=acme,host_id=1234", true},
+		{"hosts:retrieve:tenant=acme,host_id=12*", "hosts:retrieve:host_id=1234,tenant=acme", true},
+		{"hosts:retrieve:tenant=acme,host_id=12*", "hosts:retrieve:tenant=acme", false},
+		{"hosts:retrieve:*", "hosts:retrieve:tenant=acme", true},
+		{"hosts:retrieve", "hosts:retrieve:tenant=acme", true},
+		// malformed rules and requests
+		{"hosts", "hosts:search", false},
+		{"hosts:search:acme", "hosts:search", false},
+		{"hosts:search", "hosts", false},
+		{"hosts:*", "", false},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		authorized := err == nil && !rule.Deny && rule.Matches(ParseRequestedPermission(tt.reqRule))
+		assert.Equal(t, tt.authorized, authorized, "rule %q request %q", tt.rule, tt.reqRule)
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	_, err := CompileRuleSet("hosts:*", "hosts")
+	assert.Error(t, err)
+
+	rs, err := CompileRuleSet("hosts:*", "flavors:search", "!hosts:delete", "!*:*:tenant=coyote")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		reqRule string
+		allowed bool
+	}{
+		{"hosts:search", true},
+		{"hosts:create", true},
+		{"hosts:delete", false},
+		{"flavors:search", true},
+		{"flavors:delete", false},
+		{"hosts:search:tenant=acme", true},
+		{"hosts:search:tenant=coyote", false},
+		{"reports:search", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, rs.Allows(tt.reqRule), tt.reqRule)
+	}
+	assert.True(t, rs.Denies("flavors", "search", map[string]string{"tenant": "coyote"}))
+	assert.False(t, rs.Denies("flavors", "search", nil))
+}
+
+func TestDenyRulesOverrideAllow(t *testing.T) {
+	privileges := []types.PermissionInfo{
+		{Service: "HVS", Rules: []string{"hosts:*", "flavors:*"}},
+		{Service: "HVS", Context: "tenant=acme", Rules: []string{"!hosts:delete"}},
+		{Service: "WLS", Rules: []string{"!flavors:*"}},
+	}
+	tests := []struct {
+		rules   []string
+		allowed bool
+	}{
+		{[]string{"hosts:search"}, true},
+		{[]string{"hosts:delete"}, false},
+		{[]string{"hosts:search", "hosts:delete"}, false},
+		// deny rules of other services do not apply
+		{[]string{"flavors:delete"}, true},
+	}
+	for _, tt := range tests {
+		_, found := ValidatePermissionAndGetPermissionsContext(privileges, types.PermissionInfo{Service: "HVS", Rules: tt.rules}, true)
+		assert.Equal(t, tt.allowed, found, "%v", tt.rules)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package aas
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	cos "intel/isecl/lib/common/v2/os"
+	types "intel/isecl/lib/common/v2/types/aas"
+)
+
+const (
+	defaultClientTimeout time.Duration = 30 * time.Second
+	maxResponseSize      int64         = 1 << 20
+)
+
+// Client calls the authentication and authorization service. Calls other than GetToken and
+// GetJwtSigningCertificates need a token with the privileges for the call in Token.
+type Client struct {
+	BaseURL    *url.URL
+	HTTPClient *http.Client
+	Token      string
+}
+
+// NewClient returns a client for the authentication service at aasBaseUrl, for instance
+// https://aas.server:8444/aas/. The TLS certificate of the service has to be trusted by one of the CAs in
+// caCertsDir or by the system.
+func NewClient(aasBaseUrl, caCertsDir, token string) (*Client, error) {
+	if !strings.HasSuffix(aasBaseUrl, "/") {
+		aasBaseUrl = aasBaseUrl + "/"
+	}
+	baseUrl, err := url.Parse(aasBaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("authentication service url is malformed: %v", err)
+	}
+	if baseUrl.Scheme != "https" {
+		return nil, fmt.Errorf("authentication service url has to use https: %s", aasBaseUrl)
+	}
+
+	if fi, err := os.Stat(caCertsDir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("trusted CA certificate directory %s does not exist", caCertsDir)
+	}
+	rootCaCertPems, err := cos.GetDirFileContents(caCertsDir, "*.pem")
+	if err != nil {
+		return nil, fmt.Errorf("could not load trusted CA certificates: %v", err)
+	}
+	rootCAs, _ := x509.SystemCertPool()
+	if rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	for _, rootCACert := range rootCaCertPems {
+		if ok := rootCAs.AppendCertsFromPEM(rootCACert); !ok {
+			return nil, fmt.Errorf("could not add trusted CA certificate from %s", caCertsDir)
+		}
+	}
+
+	return &Client{
+		BaseURL: baseUrl,
+		HTTPClient: &http.Client{
+			Timeout: defaultClientTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: false,
+					RootCAs:            rootCAs,
+				},
+			},
+		},
+		Token: token,
+	}, nil
+}
+
+// do sends the request and decodes the json response into out unless it is nil. The response has to have the
+// expected status code, otherwise the status is returned as one of the typed errors.
+func (c *Client) do(method, path string, query url.Values, in, out interface{}, accept string, expectedStatus int) ([]byte, error) {
+	endpoint, err := c.BaseURL.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not build url for %s: %v", path, err)
+	}
+	if query != nil {
+		endpoint.RawQuery = query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		reqBytes, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode request to %s: %v", path, err)
+		}
+		body = bytes.NewBuffer(reqBytes)
+	}
+	req, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request to %s: %v", path, err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", accept)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read response of %s %s: %v", method, endpoint, err)
+	}
+	if resp.StatusCode != expectedStatus {
+		return nil, newStatusError(method, endpoint.String(), resp.StatusCode, strings.TrimSpace(string(respBytes)))
+	}
+	if out != nil {
+		if err = json.Unmarshal(respBytes, out); err != nil {
+			return nil, fmt.Errorf("could not decode response of %s %s: %v", method, endpoint, err)
+		}
+	}
+	return respBytes, nil
+}
+
+// GetToken requests a token for the user
+func (c *Client) GetToken(cred types.UserCred) (string, error) {
+	token, err := c.do("POST", "token", nil, cred, nil, "application/jwt", http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// GetJwtSigningCertificates returns the pem encoded certificates tokens are signed with
+func (c *Client) GetJwtSigningCertificates() ([]byte, error) {
+	return c.do("GET", "noauth/jwt-certificates", nil, nil, nil, "application/x-pem-file", http.StatusOK)
+}
+
+// CreateUser creates a user and returns its id
+func (c *Client) CreateUser(user types.UserCreate) (*types.UserCreateResponse, error) {
+	created := types.UserCreateResponse{}
+	if _, err := c.do("POST", "users", nil, user, &created, "application/json", http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetUsers returns the users with the name, or all users if name is empty
+func (c *Client) GetUsers(name string) ([]types.UserCreateResponse, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+	var users []types.UserCreateResponse
+	if _, err := c.do("GET", "users", query, nil, &users, "application/json", http.StatusOK); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser deletes the user with the id
+func (c *Client) DeleteUser(userId string) error {
+	_, err := c.do("DELETE", "users/"+url.PathEscape(userId), nil, nil, nil, "application/json", http.StatusNoContent)
+	return err
+}
+
+// ChangePassword changes the password of a user. The old password authenticates the request, so no token is
+// needed
+func (c *Client) ChangePassword(change types.PasswordChange) error {
+	_, err := c.do("PATCH", "users/changepassword", nil, change, nil, "application/json", http.StatusOK)
+	return err
+}
+
+// CreateRole creates a role and returns its id
+func (c *Client) CreateRole(role types.RoleCreate) (*types.RoleCreateResponse, error) {
+	created := types.RoleCreateResponse{}
+	if _, err := c.do("POST", "roles", nil, role, &created, "application/json", http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetRoles returns the roles matching the non empty fields of filter
+func (c *Client) GetRoles(filter types.RoleInfo) ([]types.RoleCreateResponse, error) {
+	query := url.Values{}
+	if filter.Service != "" {
+		query.Set("service", filter.Service)
+	}
+	if filter.Name != "" {
+		query.Set("name", filter.Name)
+	}
+	if filter.Context != "" {
+		query.Set("context", filter.Context)
+	}
+	var roles []types.RoleCreateResponse
+	if _, err := c.do("GET", "roles", query, nil, &roles, "application/json", http.StatusOK); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// DeleteRole deletes the role with the id
+func (c *Client) DeleteRole(roleId string) error {
+	_, err := c.do("DELETE", "roles/"+url.PathEscape(roleId), nil, nil, nil, "application/json", http.StatusNoContent)
+	return err
+}
+
+// AddRolesToUser assigns the roles in userRoles.RoleIds to the user with the id userRoles.ID
+func (c *Client) AddRolesToUser(userRoles types.UserRoleCreate) error {
+	_, err := c.do("POST", "users/"+url.PathEscape(userRoles.ID)+"/roles", nil, userRoles.RoleIds, nil, "application/json", http.StatusCreated)
+	return err
+}
+
+// GetRolesForUser returns the roles assigned to the user
+func (c *Client) GetRolesForUser(userId string) ([]types.RoleCreateResponse, error) {
+	var roles []types.RoleCreateResponse
+	if _, err := c.do("GET", "users/"+url.PathEscape(userId)+"/roles", nil, nil, &roles, "application/json", http.StatusOK); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// DeleteRoleFromUser removes the role from the user
+func (c *Client) DeleteRoleFromUser(userId, roleId string) error {
+	_, err := c.do("DELETE", "users/"+url.PathEscape(userId)+"/roles/"+url.PathEscape(roleId), nil, nil, nil, "application/json", http.StatusNoContent)
+	return err
+}
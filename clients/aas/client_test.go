@@ -0,0 +1,301 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package aas
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "header.claims.signature"
+
+// fakeAas keeps users and roles in memory and implements enough of the authentication service api to exercise
+// the client
+type fakeAas struct {
+	mtx       sync.Mutex
+	users     map[string]types.UserCreate
+	roles     map[string]types.RoleCreate
+	userRoles map[string][]string
+	nextId    int
+}
+
+func (f *fakeAas) id() string {
+	f.nextId++
+	return fmt.Sprintf("%08d-0000-0000-0000-000000000000", f.nextId)
+}
+
+func (f *fakeAas) router() http.Handler {
+	writeJson := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	authenticated := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testToken {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			f.mtx.Lock()
+			defer f.mtx.Unlock()
+			handler(w, r)
+		}
+	}
+
+	router := mux.NewRouter().PathPrefix("/aas").Subrouter()
+	router.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		cred := types.UserCred{}
+		json.NewDecoder(r.Body).Decode(&cred)
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		for _, user := range f.users {
+			if user.Name == cred.UserName && user.Password == cred.Password {
+				w.Header().Set("Content-Type", "application/jwt")
+				w.Write([]byte(testToken))
+				return
+			}
+		}
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+	}).Methods("POST")
+	router.HandleFunc("/users", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		user := types.UserCreate{}
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Name == "" {
+			http.Error(w, "invalid user", http.StatusBadRequest)
+			return
+		}
+		for _, existing := range f.users {
+			if existing.Name == user.Name {
+				http.Error(w, "user already exists", http.StatusConflict)
+				return
+			}
+		}
+		id := f.id()
+		f.users[id] = user
+		writeJson(w, http.StatusCreated, types.UserCreateResponse{ID: id, Name: user.Name})
+	})).Methods("POST")
+	router.HandleFunc("/users", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		users := []types.UserCreateResponse{}
+		for id, user := range f.users {
+			if name := r.URL.Query().Get("name"); name == "" || name == user.Name {
+				users = append(users, types.UserCreateResponse{ID: id, Name: user.Name})
+			}
+		}
+		writeJson(w, http.StatusOK, users)
+	})).Methods("GET")
+	router.HandleFunc("/users/changepassword", func(w http.ResponseWriter, r *http.Request) {
+		change := types.PasswordChange{}
+		json.NewDecoder(r.Body).Decode(&change)
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		for id, user := range f.users {
+			if user.Name == change.UserName && user.Password == change.OldPassword {
+				if change.NewPassword != change.PasswordConfirm {
+					http.Error(w, "passwords do not match", http.StatusBadRequest)
+					return
+				}
+				user.Password = change.NewPassword
+				f.users[id] = user
+				return
+			}
+		}
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+	}).Methods("PATCH")
+	router.HandleFunc("/users/{id}", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, found := f.users[id]; !found {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		delete(f.users, id)
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods("DELETE")
+	router.HandleFunc("/roles", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		role := types.RoleCreate{}
+		json.NewDecoder(r.Body).Decode(&role)
+		id := f.id()
+		f.roles[id] = role
+		writeJson(w, http.StatusCreated, types.RoleCreateResponse{ID: id, Service: role.Service, Name: role.Name})
+	})).Methods("POST")
+	router.HandleFunc("/roles", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		roles := []types.RoleCreateResponse{}
+		for id, role := range f.roles {
+			if service := r.URL.Query().Get("service"); service == "" || service == role.Service {
+				roles = append(roles, types.RoleCreateResponse{ID: id, Service: role.Service, Name: role.Name})
+			}
+		}
+		writeJson(w, http.StatusOK, roles)
+	})).Methods("GET")
+	router.HandleFunc("/roles/{id}", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		delete(f.roles, mux.Vars(r)["id"])
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods("DELETE")
+	router.HandleFunc("/users/{id}/roles", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		roleIds := types.RoleIDs{}
+		json.NewDecoder(r.Body).Decode(&roleIds)
+		id := mux.Vars(r)["id"]
+		f.userRoles[id] = append(f.userRoles[id], roleIds.RoleUUIDs...)
+		w.WriteHeader(http.StatusCreated)
+	})).Methods("POST")
+	router.HandleFunc("/users/{id}/roles", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		roles := []types.RoleCreateResponse{}
+		for _, roleId := range f.userRoles[mux.Vars(r)["id"]] {
+			role := f.roles[roleId]
+			roles = append(roles, types.RoleCreateResponse{ID: roleId, Service: role.Service, Name: role.Name})
+		}
+		writeJson(w, http.StatusOK, roles)
+	})).Methods("GET")
+	router.HandleFunc("/users/{id}/roles/{role_id}", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var remaining []string
+		for _, roleId := range f.userRoles[id] {
+			if roleId != mux.Vars(r)["role_id"] {
+				remaining = append(remaining, roleId)
+			}
+		}
+		f.userRoles[id] = remaining
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods("DELETE")
+	return router
+}
+
+func newFakeAasClient(t *testing.T) (*Client, *fakeAas, func()) {
+	fake := &fakeAas{
+		users:     map[string]types.UserCreate{"00000000-0000-0000-0000-000000000000": {Name: "admin", Password: "password"}},
+		roles:     make(map[string]types.RoleCreate),
+		userRoles: make(map[string][]string),
+	}
+	server := httptest.NewTLSServer(fake.router())
+	caCertsDir, err := ioutil.TempDir("", "ca-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCertPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = crypt.SavePemCertWithShortSha1FileName(serverCertPem, caCertsDir); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(server.URL+"/aas", caCertsDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, fake, func() {
+		server.Close()
+		os.RemoveAll(caCertsDir)
+	}
+}
+
+func TestClientTokenAndUsers(t *testing.T) {
+	client, _, cleanup := newFakeAasClient(t)
+	defer cleanup()
+
+	_, err := client.GetToken(types.UserCred{UserName: "admin", Password: "wrong"})
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	token, err := client.GetToken(types.UserCred{UserName: "admin", Password: "password"})
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, token)
+
+	_, err = client.CreateUser(types.UserCreate{Name: "hvs", Password: "hvs-password"})
+	assert.IsType(t, &UnauthorizedError{}, err)
+
+	client.Token = token
+	user, err := client.CreateUser(types.UserCreate{Name: "hvs", Password: "hvs-password"})
+	assert.NoError(t, err)
+	assert.Equal(t, "hvs", user.Name)
+	assert.NotEmpty(t, user.ID)
+
+	_, err = client.CreateUser(types.UserCreate{Name: "hvs", Password: "hvs-password"})
+	if assert.IsType(t, &ConflictError{}, err) {
+		assert.Equal(t, http.StatusConflict, err.(*ConflictError).StatusCode)
+		assert.Contains(t, err.Error(), "user already exists")
+	}
+	_, err = client.CreateUser(types.UserCreate{})
+	assert.IsType(t, &BadRequestError{}, err)
+
+	users, err := client.GetUsers("hvs")
+	assert.NoError(t, err)
+	assert.Equal(t, []types.UserCreateResponse{*user}, users)
+
+	assert.NoError(t, client.ChangePassword(types.PasswordChange{UserName: "hvs", OldPassword: "hvs-password", NewPassword: "new", PasswordConfirm: "new"}))
+	_, err = client.GetToken(types.UserCred{UserName: "hvs", Password: "new"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.DeleteUser(user.ID))
+	assert.IsType(t, &NotFoundError{}, client.DeleteUser(user.ID))
+}
+
+func TestClientRoles(t *testing.T) {
+	client, _, cleanup := newFakeAasClient(t)
+	defer cleanup()
+	client.Token = testToken
+
+	role, err := client.CreateRole(types.RoleCreate{
+		RoleInfo:    types.RoleInfo{Service: "HVS", Name: "HostManager", Context: "tenant=acme"},
+		Permissions: []string{"hosts:*"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "HostManager", role.Name)
+
+	roles, err := client.GetRoles(types.RoleInfo{Service: "HVS"})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.RoleCreateResponse{*role}, roles)
+	roles, err = client.GetRoles(types.RoleInfo{Service: "WLS"})
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+
+	user, err := client.CreateUser(types.UserCreate{Name: "hvs", Password: "hvs-password"})
+	assert.NoError(t, err)
+	assert.NoError(t, client.AddRolesToUser(types.UserRoleCreate{ID: user.ID, RoleIds: types.RoleIDs{RoleUUIDs: []string{role.ID}}}))
+	userRoles, err := client.GetRolesForUser(user.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, userRoles, 1) {
+		assert.Equal(t, "HostManager", userRoles[0].Name)
+	}
+
+	assert.NoError(t, client.DeleteRoleFromUser(user.ID, role.ID))
+	userRoles, err = client.GetRolesForUser(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, userRoles)
+	assert.NoError(t, client.DeleteRole(role.ID))
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient("http://aas.server:8444/aas", "", "")
+	assert.Error(t, err)
+	_, err = NewClient("https://aas.server:8444/aas", os.TempDir()+"/no-such-dir", "")
+	assert.Error(t, err)
+
+	// the TLS certificate of the server is not trusted by the CA in the directory
+	caCertsDir, err := ioutil.TempDir("", "ca-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(caCertsDir)
+	caCertDer, _, err := crypt.CreateKeyPairAndCertificate("Test Root CA", "", "ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertDer}), caCertsDir))
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	client, err := NewClient(server.URL+"/aas/", caCertsDir, "")
+	assert.NoError(t, err)
+	_, err = client.GetJwtSigningCertificates()
+	assert.Error(t, err)
+	_, isStatusErr := err.(*NotFoundError)
+	assert.False(t, isStatusErr)
+}
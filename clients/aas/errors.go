@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package aas
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatusError is returned when the authentication service responds with an unexpected status code. The more
+// common statuses are returned as the error types below, which embed StatusError.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%s %s failed with HTTP status code %d: %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// BadRequestError is returned for 400 Bad Request responses
+type BadRequestError struct {
+	StatusError
+}
+
+// UnauthorizedError is returned for 401 Unauthorized responses, i.e. the token is missing, invalid or expired or
+// the credentials are wrong
+type UnauthorizedError struct {
+	StatusError
+}
+
+// ForbiddenError is returned for 403 Forbidden responses
+type ForbiddenError struct {
+	StatusError
+}
+
+// NotFoundError is returned for 404 Not Found responses
+type NotFoundError struct {
+	StatusError
+}
+
+// ConflictError is returned for 409 Conflict responses, i.e. the user or role already exists
+type ConflictError struct {
+	StatusError
+}
+
+func newStatusError(method, url string, statusCode int, message string) error {
+	statusErr := StatusError{Method: method, URL: url, StatusCode: statusCode, Message: message}
+	switch statusCode {
+	case http.StatusBadRequest:
+		return &BadRequestError{statusErr}
+	case http.StatusUnauthorized:
+		return &UnauthorizedError{statusErr}
+	case http.StatusForbidden:
+		return &ForbiddenError{statusErr}
+	case http.StatusNotFound:
+		return &NotFoundError{statusErr}
+	case http.StatusConflict:
+		return &ConflictError{statusErr}
+	default:
+		return &statusErr
+	}
+}
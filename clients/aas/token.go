@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package aas
+
+import (
+	"fmt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultRefreshBefore is how long before expiry a cached token is replaced
+const defaultRefreshBefore time.Duration = 5 * time.Minute
+
+// TokenSourceOptions configures a TokenSource
+type TokenSourceOptions struct {
+	// RefreshBefore is how long before the token expires a new one is requested. Defaults to 5 minutes
+	RefreshBefore time.Duration
+	// Verifier validates the tokens issued by the authentication service. If it is nil the tokens are only
+	// parsed to find out when they expire
+	Verifier jwtauth.Verifier
+}
+
+// TokenSource requests a token from the authentication service with the credentials of a service user and
+// caches it until shortly before it expires. It is safe for concurrent use.
+type TokenSource struct {
+	client  *Client
+	cred    types.UserCred
+	options TokenSourceOptions
+
+	mtx       sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenSource returns a TokenSource that logs in to the authentication service through client with cred
+func NewTokenSource(client *Client, cred types.UserCred, options ...TokenSourceOptions) *TokenSource {
+	ts := &TokenSource{client: client, cred: cred}
+	if len(options) > 0 {
+		ts.options = options[0]
+	}
+	if ts.options.RefreshBefore == 0 {
+		ts.options.RefreshBefore = defaultRefreshBefore
+	}
+	return ts
+}
+
+// expiry parses the token and returns its exp claim, or the zero time if the token does not expire
+func (ts *TokenSource) expiry(token string) (time.Time, error) {
+	var parsed *jwtauth.Token
+	var err error
+	if ts.options.Verifier != nil {
+		parsed, err = ts.options.Verifier.ValidateTokenAndGetClaims(token, &types.AuthClaims{})
+	} else {
+		parsed, err = jwtauth.ParseTokenUnverified(token, nil)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.GetExpiresAt(), nil
+}
+
+// Token returns the cached token, or requests a new one if there is none or the cached one is about to expire
+func (ts *TokenSource) Token() (string, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if ts.token != "" && (ts.expiresAt.IsZero() || time.Now().Add(ts.options.RefreshBefore).Before(ts.expiresAt)) {
+		return ts.token, nil
+	}
+	token, err := ts.client.GetToken(ts.cred)
+	if err != nil {
+		return "", fmt.Errorf("could not get token for %s: %v", ts.cred.UserName, err)
+	}
+	expiresAt, err := ts.expiry(token)
+	if err != nil {
+		return "", fmt.Errorf("token issued for %s is invalid: %v", ts.cred.UserName, err)
+	}
+	ts.token = token
+	ts.expiresAt = expiresAt
+	return token, nil
+}
+
+// Invalidate drops the cached token if it is still token, so that the next call to Token requests a new one.
+// It is called when a service rejects the token before it expires.
+func (ts *TokenSource) Invalidate(token string) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	if ts.token == token {
+		ts.token = ""
+		ts.expiresAt = time.Time{}
+	}
+}
+
+// Transport is an http.RoundTripper that sets the Authorization header of requests to a token from Source.
+// When the response is 401 Unauthorized, the token is invalidated and the request is sent once more with a new
+// token, provided the request body can be read again (see http.Request.GetBody).
+type Transport struct {
+	Source *TokenSource
+	// Base sends the requests. Defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) send(req *http.Request) (*http.Response, string, error) {
+	token, err := t.Source.Token()
+	if err != nil {
+		return nil, "", err
+	}
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := t.base().RoundTrip(authReq)
+	return resp, token, err
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	resp, token, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.Source.Invalidate(token)
+	retryReq := req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retryReq = req.Clone(req.Context())
+		retryReq.Body = body
+	}
+	resp.Body.Close()
+	resp, _, err = t.send(retryReq)
+	return resp, err
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package aas
+
+import (
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	"intel/isecl/lib/common/v2/test/fake"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeAasTokenSource(t *testing.T, options ...TokenSourceOptions) (*fake.AAS, *TokenSource, func()) {
+	ca, err := fake.NewCA("Fake Root CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aas, err := fake.NewAAS(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertsDir, err := ioutil.TempDir("", "ca-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		aas.Close()
+		os.RemoveAll(caCertsDir)
+	}
+	assert.NoError(t, ca.SaveCert(caCertsDir))
+	client, err := NewClient(aas.BaseURL, caCertsDir, "")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	aas.AddUser("hvs-service", "password", []types.RoleInfo{{Service: "CMS", Name: "CertApprover"}}, nil)
+	return aas, NewTokenSource(client, types.UserCred{UserName: "hvs-service", Password: "password"}, options...), cleanup
+}
+
+func TestTokenSourceCachesToken(t *testing.T) {
+	_, ts, cleanup := newFakeAasTokenSource(t)
+	defer cleanup()
+
+	token, err := ts.Token()
+	assert.NoError(t, err)
+	cached, err := ts.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, token, cached)
+
+	ts.Invalidate("some other token")
+	cached, _ = ts.Token()
+	assert.Equal(t, token, cached)
+	ts.Invalidate(token)
+	refreshed, err := ts.Token()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, refreshed)
+}
+
+func TestTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	// the fake AAS issues tokens valid for an hour, so they are always about to expire
+	_, ts, cleanup := newFakeAasTokenSource(t, TokenSourceOptions{RefreshBefore: 2 * time.Hour})
+	defer cleanup()
+
+	token, err := ts.Token()
+	assert.NoError(t, err)
+	refreshed, err := ts.Token()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, refreshed)
+}
+
+func TestTokenSourceVerifier(t *testing.T) {
+	aas, _, cleanup := newFakeAasTokenSource(t)
+	defer cleanup()
+
+	trusting, err := jwtauth.NewVerifier(aas.SigningCertPem, [][]byte{aas.CA.CertPem}, time.Hour)
+	assert.NoError(t, err)
+	_, ts, cleanup2 := newFakeAasTokenSource(t, TokenSourceOptions{Verifier: trusting})
+	defer cleanup2()
+
+	// the second fake AAS has a different signing key
+	_, err = ts.Token()
+	assert.Error(t, err)
+	ts.options.Verifier, _ = jwtauth.NewVerifier(nil, nil, time.Hour)
+	_, err = ts.Token()
+	assert.Error(t, err)
+}
+
+func TestTransportRetriesOnUnauthorized(t *testing.T) {
+	_, ts, cleanup := newFakeAasTokenSource(t)
+	defer cleanup()
+	rejected, err := ts.Token()
+	assert.NoError(t, err)
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") == "Bearer "+rejected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Source: ts}}
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"name":"host"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, []string{`{"name":"host"}`, `{"name":"host"}`}, bodies)
+
+	// the new token is used without another retry
+	bodies = nil
+	resp, err = client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	assert.Len(t, bodies, 1)
+
+	// only one retry is made
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bodies = append(bodies, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	bodies = nil
+	resp, err = client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+	assert.Len(t, bodies, 2)
+	assert.NotEqual(t, bodies[0], bodies[1])
+}
@@ -8,36 +8,95 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	types "intel/isecl/lib/common/v2/types/aas"
 )
 
 type httpContextKey string
 
-var userRoleKey = httpContextKey("userroles")
-var userPermissionKey = httpContextKey("userpermissions")
+// keys the roles and permissions were stored under before the Identity was introduced. They are still read, so
+// that contexts seeded with context.WithValue keep working
+const (
+	legacyUserRolesKey       = "userroles"
+	legacyUserPermissionsKey = "userpermissions"
+)
 
-func SetUserRoles(r *http.Request, val []types.RoleInfo) *http.Request {
+var identityKey = httpContextKey("identity")
+var rolesContextKey = httpContextKey("rolescontext")
+var permissionsContextKey = httpContextKey("permissionscontext")
+
+// methods a caller can be authenticated with
+const (
+	AuthMethodBearerToken = "bearer-token"
+	AuthMethodClientCert  = "client-certificate"
+)
 
-	ctx := context.WithValue(r.Context(), "userroles", val)
-	return r.WithContext(ctx)
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject     string
+	Issuer      string
+	TokenId     string
+	ExpiresAt   time.Time
+	Roles       []types.RoleInfo
+	Permissions []types.PermissionInfo
+	AuthMethod  string
+
+	// rolesSet and permissionsSet record that roles or permissions were set explicitly, even if to nil
+	rolesSet       bool
+	permissionsSet bool
 }
 
-func SetUserPermissions(r *http.Request, val []types.PermissionInfo) *http.Request {
+// WithIdentity returns a copy of ctx that carries the identity of the caller
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
 
-	ctx := context.WithValue(r.Context(), "userpermissions", val)
-	return r.WithContext(ctx)
+// IdentityFromContext returns the identity of the caller stored with WithIdentity, SetIdentity or any of the
+// functions setting user roles and permissions
+func IdentityFromContext(ctx context.Context) (*Identity, error) {
+	if iv := ctx.Value(identityKey); iv != nil {
+		if identity, ok := iv.(*Identity); ok && identity != nil {
+			return identity, nil
+		}
+	}
+	return nil, fmt.Errorf("could not retrieve caller identity from context")
 }
 
-/*
- func SetUserRoles(r *http.Request, val types.Roles) *http.Request {
+// updateIdentity stores a modified copy of the identity in ctx, so that the identity seen by parent contexts
+// does not change
+func updateIdentity(ctx context.Context, update func(*Identity)) context.Context {
+	identity := Identity{}
+	if current, err := IdentityFromContext(ctx); err == nil {
+		identity = *current
+	}
+	update(&identity)
+	return WithIdentity(ctx, &identity)
+}
 
-	 ctx := context.WithValue(r.Context(), userRoleKey, val)
-	 return r.WithContext(ctx)
- }
-*/
-func GetUserRoles(r *http.Request) ([]types.RoleInfo, error) {
-	if rv := r.Context().Value("userroles"); rv != nil {
+// WithUserRoles returns a copy of ctx with the roles of the caller set on its identity
+func WithUserRoles(ctx context.Context, val []types.RoleInfo) context.Context {
+	return updateIdentity(ctx, func(identity *Identity) {
+		identity.Roles = val
+		identity.rolesSet = true
+	})
+}
+
+// WithUserPermissions returns a copy of ctx with the permissions of the caller set on its identity
+func WithUserPermissions(ctx context.Context, val []types.PermissionInfo) context.Context {
+	return updateIdentity(ctx, func(identity *Identity) {
+		identity.Permissions = val
+		identity.permissionsSet = true
+	})
+}
+
+// UserRolesFromContext returns the roles of the caller. Roles stored under the legacy "userroles" key are returned
+// if the identity has none. It fails if no roles were set
+func UserRolesFromContext(ctx context.Context) ([]types.RoleInfo, error) {
+	if identity, err := IdentityFromContext(ctx); err == nil && (identity.Roles != nil || identity.rolesSet) {
+		return identity.Roles, nil
+	}
+	if rv := ctx.Value(legacyUserRolesKey); rv != nil {
 		if ur, ok := rv.([]types.RoleInfo); ok {
 			return ur, nil
 		}
@@ -45,11 +104,91 @@ func GetUserRoles(r *http.Request) ([]types.RoleInfo, error) {
 	return nil, fmt.Errorf("could not retrieve user roles from context")
 }
 
-func GetUserPermissions(r *http.Request) ([]types.PermissionInfo, error) {
-	if rv := r.Context().Value("userpermissions"); rv != nil {
-		if ur, ok := rv.([]types.PermissionInfo); ok {
-			return ur, nil
+// UserPermissionsFromContext returns the permissions of the caller. Permissions stored under the legacy
+// "userpermissions" key are returned if the identity has none. It fails if no permissions were set
+func UserPermissionsFromContext(ctx context.Context) ([]types.PermissionInfo, error) {
+	if identity, err := IdentityFromContext(ctx); err == nil && (identity.Permissions != nil || identity.permissionsSet) {
+		return identity.Permissions, nil
+	}
+	if pv := ctx.Value(legacyUserPermissionsKey); pv != nil {
+		if up, ok := pv.([]types.PermissionInfo); ok {
+			return up, nil
 		}
 	}
 	return nil, fmt.Errorf("could not retrieve user permissions from context")
 }
+
+// WithRolesContext returns a copy of ctx that carries the role context map returned by
+// auth.ValidatePermissionAndGetRoleContext. A nil map means that the caller is not restricted to any context
+func WithRolesContext(ctx context.Context, val *map[string]types.RoleInfo) context.Context {
+	return context.WithValue(ctx, rolesContextKey, val)
+}
+
+func RolesContextFromContext(ctx context.Context) (*map[string]types.RoleInfo, error) {
+	if rv := ctx.Value(rolesContextKey); rv != nil {
+		if rc, ok := rv.(*map[string]types.RoleInfo); ok {
+			return rc, nil
+		}
+	}
+	return nil, fmt.Errorf("could not retrieve roles context from context")
+}
+
+// WithPermissionsContext returns a copy of ctx that carries the permission context map returned by
+// auth.ValidatePermissionAndGetPermissionsContext. A nil map means that the caller is not restricted to any context
+func WithPermissionsContext(ctx context.Context, val *map[string]types.PermissionInfo) context.Context {
+	return context.WithValue(ctx, permissionsContextKey, val)
+}
+
+func PermissionsContextFromContext(ctx context.Context) (*map[string]types.PermissionInfo, error) {
+	if pv := ctx.Value(permissionsContextKey); pv != nil {
+		if pc, ok := pv.(*map[string]types.PermissionInfo); ok {
+			return pc, nil
+		}
+	}
+	return nil, fmt.Errorf("could not retrieve permissions context from context")
+}
+
+// SetIdentity stores the identity of the caller on the request
+func SetIdentity(r *http.Request, identity *Identity) *http.Request {
+	return r.WithContext(WithIdentity(r.Context(), identity))
+}
+
+func GetIdentity(r *http.Request) (*Identity, error) {
+	return IdentityFromContext(r.Context())
+}
+
+func SetUserRoles(r *http.Request, val []types.RoleInfo) *http.Request {
+	return r.WithContext(WithUserRoles(r.Context(), val))
+}
+
+func SetUserPermissions(r *http.Request, val []types.PermissionInfo) *http.Request {
+	return r.WithContext(WithUserPermissions(r.Context(), val))
+}
+
+func GetUserRoles(r *http.Request) ([]types.RoleInfo, error) {
+	return UserRolesFromContext(r.Context())
+}
+
+func GetUserPermissions(r *http.Request) ([]types.PermissionInfo, error) {
+	return UserPermissionsFromContext(r.Context())
+}
+
+// SetRolesContext stores the role context map returned by auth.ValidatePermissionAndGetRoleContext. A nil map
+// means that the caller is not restricted to any context
+func SetRolesContext(r *http.Request, val *map[string]types.RoleInfo) *http.Request {
+	return r.WithContext(WithRolesContext(r.Context(), val))
+}
+
+func GetRolesContext(r *http.Request) (*map[string]types.RoleInfo, error) {
+	return RolesContextFromContext(r.Context())
+}
+
+// SetPermissionsContext stores the permission context map returned by
+// auth.ValidatePermissionAndGetPermissionsContext. A nil map means that the caller is not restricted to any context
+func SetPermissionsContext(r *http.Request, val *map[string]types.PermissionInfo) *http.Request {
+	return r.WithContext(WithPermissionsContext(r.Context(), val))
+}
+
+func GetPermissionsContext(r *http.Request) (*map[string]types.PermissionInfo, error) {
+	return PermissionsContextFromContext(r.Context())
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package context
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	types "intel/isecl/lib/common/v2/types/aas"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityFromContext(t *testing.T) {
+	ctx := context.Background()
+	_, err := IdentityFromContext(ctx)
+	assert.Error(t, err)
+	_, err = UserRolesFromContext(ctx)
+	assert.Error(t, err)
+
+	identity := &Identity{Subject: "admin", AuthMethod: AuthMethodBearerToken}
+	ctx = WithIdentity(ctx, identity)
+	roles := []types.RoleInfo{{Service: "HVS", Name: "Administrator"}}
+	withRoles := WithUserRoles(ctx, roles)
+
+	// setting roles does not change the identity seen through the parent context
+	assert.Nil(t, identity.Roles)
+	got, err := IdentityFromContext(withRoles)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", got.Subject)
+	assert.Equal(t, roles, got.Roles)
+
+	// roles and permissions set by themselves can be read through the identity
+	ctx = WithUserPermissions(context.Background(), []types.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}})
+	permissions, err := UserPermissionsFromContext(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, permissions, 1)
+	got, err = IdentityFromContext(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, got.Subject)
+}
+
+func TestRequestWrappers(t *testing.T) {
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	_, err := GetUserRoles(req)
+	assert.Error(t, err)
+
+	req = SetUserRoles(req, []types.RoleInfo{{Service: "HVS", Name: "Administrator"}})
+	req = SetUserPermissions(req, nil)
+	roles, err := GetUserRoles(req)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	permissions, err := UserPermissionsFromContext(req.Context())
+	assert.NoError(t, err)
+	assert.Nil(t, permissions)
+
+	permissionsContext := map[string]types.PermissionInfo{"tenant=acme": {Service: "HVS"}}
+	req = SetPermissionsContext(req, &permissionsContext)
+	got, err := PermissionsContextFromContext(req.Context())
+	assert.NoError(t, err)
+	assert.Equal(t, &permissionsContext, got)
+}
+
+func TestUserRolesAndPermissionsNotSet(t *testing.T) {
+	// an identity without roles or permissions does not make them appear set
+	ctx := WithIdentity(context.Background(), &Identity{Subject: "admin"})
+	_, err := UserRolesFromContext(ctx)
+	assert.Error(t, err)
+	_, err = UserPermissionsFromContext(ctx)
+	assert.Error(t, err)
+
+	ctx = WithUserRoles(ctx, []types.RoleInfo{{Service: "HVS", Name: "Administrator"}})
+	_, err = UserPermissionsFromContext(ctx)
+	assert.Error(t, err)
+}
+
+func TestLegacyContextKeys(t *testing.T) {
+	roles := []types.RoleInfo{{Service: "HVS", Name: "Administrator"}}
+	permissions := []types.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:retrieve"}}}
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "userroles", roles))
+	req = req.WithContext(context.WithValue(req.Context(), "userpermissions", permissions))
+
+	gotRoles, err := GetUserRoles(req)
+	assert.NoError(t, err)
+	assert.Equal(t, roles, gotRoles)
+	gotPermissions, err := GetUserPermissions(req)
+	assert.NoError(t, err)
+	assert.Equal(t, permissions, gotPermissions)
+
+	// roles set on the identity take precedence
+	req = SetUserRoles(req, []types.RoleInfo{{Service: "AAS", Name: "RoleManager"}})
+	gotRoles, err = GetUserRoles(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "RoleManager", gotRoles[0].Name)
+	gotPermissions, err = GetUserPermissions(req)
+	assert.NoError(t, err)
+	assert.Equal(t, permissions, gotPermissions)
+}
@@ -8,5 +8,7 @@ package crypt
 const (
 	EncryptionHeaderMagicText = "ISecL-VMC"
 	EncryptionHeaderVersion   = "V1"
-	GCMEncryptionAlgorithm    = "GCM-256"
+	// files encrypted in chunks, see EncryptChunkedStream
+	ChunkedEncryptionHeaderVersion = "V2"
+	GCMEncryptionAlgorithm         = "GCM-256"
 )
@@ -7,6 +7,8 @@ package crypt
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -15,6 +17,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
@@ -156,6 +159,75 @@ func HashAndSignPKCS1v15(data []byte, rsaPriv *rsa.PrivateKey, alg crypto.Hash)
 
 }
 
+// HashAndSign creates a signature of the data with the private key, which may also be a crypto.Signer. RSA keys
+// produce a PKCS#1 v1.5 signature and ECDSA keys an ASN.1 encoded signature of the hash. Ed25519 signs the data
+// itself, so alg is ignored
+func HashAndSign(data []byte, privKey crypto.PrivateKey, alg crypto.Hash) ([]byte, error) {
+
+	switch key := privKey.(type) {
+	case ed25519.PrivateKey:
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid ed25519 private key length")
+		}
+		return ed25519.Sign(key, data), nil
+	case *rsa.PrivateKey:
+		return HashAndSignPKCS1v15(data, key, alg)
+	case *ecdsa.PrivateKey:
+		hash, err := GetHashData(data, alg)
+		if err != nil {
+			return nil, err
+		}
+		return key.Sign(rand.Reader, hash, alg)
+	case crypto.Signer:
+		// keys that are not held in memory, like the ones of a keystore.KeyStore
+		switch key.Public().(type) {
+		case ed25519.PublicKey:
+			return key.Sign(rand.Reader, data, crypto.Hash(0))
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			hash, err := GetHashData(data, alg)
+			if err != nil {
+				return nil, err
+			}
+			return key.Sign(rand.Reader, hash, alg)
+		}
+	}
+	return nil, fmt.Errorf("unsupported private key type for signing. Only rsa, ecdsa and ed25519 supported")
+}
+
+// VerifySignature verifies a signature created by HashAndSign with the matching public key
+func VerifySignature(data []byte, signature []byte, pubKey crypto.PublicKey, alg crypto.Hash) error {
+
+	switch key := pubKey.(type) {
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, data, signature) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		hash, err := GetHashData(data, alg)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(key, alg, hash, signature)
+	case *ecdsa.PublicKey:
+		hash, err := GetHashData(data, alg)
+		if err != nil {
+			return err
+		}
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &ecdsaSig); err != nil || len(rest) != 0 {
+			return fmt.Errorf("could not parse ecdsa signature")
+		}
+		if !ecdsa.Verify(key, hash, ecdsaSig.R, ecdsaSig.S) {
+			return fmt.Errorf("ecdsa signature verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type for signature verification. Only rsa, ecdsa and ed25519 supported")
+}
+
 // GetCertHexSha384 returns SHA384 of a certificate that is stored on disk given a filepath
 func GetCertHexSha384(filePath string) (string, error) {
 	certPEM, err := ioutil.ReadFile(filePath)
@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAndSign(t *testing.T) {
+	data := []byte("trust report")
+	for _, keyType := range []string{"rsa", "ecdsa", "ed25519"} {
+		t.Run(keyType, func(t *testing.T) {
+			privKey, pubKey, err := GenerateKeyPair(keyType, 0)
+			assert.NoError(t, err)
+
+			signature, err := HashAndSign(data, privKey, crypto.SHA384)
+			assert.NoError(t, err)
+			assert.NoError(t, VerifySignature(data, signature, pubKey, crypto.SHA384))
+			assert.Error(t, VerifySignature([]byte("tampered report"), signature, pubKey, crypto.SHA384))
+		})
+	}
+}
+
+func TestEd25519KeyPairAndCertificate(t *testing.T) {
+	certDer, pkcs8Der, err := CreateKeyPairAndCertificate("ed25519 test", "localhost,127.0.0.1", "ed25519", 0)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(certDer)
+	assert.NoError(t, err)
+	assert.Equal(t, x509.PureEd25519, cert.SignatureAlgorithm)
+	assert.NoError(t, cert.CheckSignatureFrom(cert))
+
+	privKey, err := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	assert.NoError(t, err)
+	pubKey, err := GetPublicKeyFromCert(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, privKey.(ed25519.PrivateKey).Public(), pubKey)
+
+	csrDer, _, err := CreateKeyPairAndCertificateRequest(pkix.Name{CommonName: "ed25519 test"}, "localhost", "ed25519", 0)
+	assert.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(csrDer)
+	assert.NoError(t, err)
+	assert.NoError(t, csr.CheckSignature())
+}
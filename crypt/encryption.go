@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// AES256KeySize is the size of the keys used to encrypt files
+const AES256KeySize = 32
+
+// encryptionHeaderSize is the size of the EncryptionHeader in the encrypted file
+var encryptionHeaderSize = binary.Size(EncryptionHeader{})
+
+// headerField returns the value of a fixed size header field without the zero padding
+func headerField(field []byte) string {
+	return string(bytes.TrimRight(field, "\x00"))
+}
+
+// NewEncryptionHeader returns the header of a file encrypted with the current version of the format and a random
+// IV
+func NewEncryptionHeader() (*EncryptionHeader, error) {
+	header := EncryptionHeader{}
+	copy(header.MagicText[:], EncryptionHeaderMagicText)
+	copy(header.Version[:], EncryptionHeaderVersion)
+	copy(header.EncryptionAlgorithm[:], GCMEncryptionAlgorithm)
+	header.OffsetInLittleEndian = uint32(encryptionHeaderSize)
+	iv, err := GetRandomBytes(len(header.IV))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate IV: %v", err)
+	}
+	copy(header.IV[:], iv)
+	return &header, nil
+}
+
+// ReadEncryptionHeader reads the header of an encrypted file from r and checks that the file is encrypted in a
+// supported format. For V1 files the ChunkSize and KeyId of the returned header are empty. r is positioned at the
+// start of the encrypted data afterwards.
+func ReadEncryptionHeader(r io.Reader) (*EncryptionHeaderV2, error) {
+	header, _, err := readEncryptionHeader(r)
+	return header, err
+}
+
+// readEncryptionHeader reads the header and returns it along with the header extension of V2 files, i.e. the data
+// between the header and the offset of the encrypted data
+func readEncryptionHeader(r io.Reader) (*EncryptionHeaderV2, []byte, error) {
+	header := EncryptionHeaderV2{}
+	if err := binary.Read(r, binary.LittleEndian, &header.EncryptionHeader); err != nil {
+		return nil, nil, fmt.Errorf("could not read encryption header: %v", err)
+	}
+	if headerField(header.MagicText[:]) != EncryptionHeaderMagicText {
+		return nil, nil, fmt.Errorf("data is not encrypted, magic text %s not found", EncryptionHeaderMagicText)
+	}
+	if alg := headerField(header.EncryptionAlgorithm[:]); alg != GCMEncryptionAlgorithm {
+		return nil, nil, fmt.Errorf("unsupported encryption algorithm %q", alg)
+	}
+
+	switch version := headerField(header.Version[:]); version {
+	case EncryptionHeaderVersion:
+		if int(header.OffsetInLittleEndian) < encryptionHeaderSize {
+			return nil, nil, fmt.Errorf("invalid offset %d of encrypted data", header.OffsetInLittleEndian)
+		}
+		// skip anything between the header and the encrypted data
+		skip := int64(header.OffsetInLittleEndian) - int64(encryptionHeaderSize)
+		if n, err := io.CopyN(ioutil.Discard, r, skip); n != skip {
+			return nil, nil, fmt.Errorf("encrypted data is truncated: %v", err)
+		}
+		return &header, nil, nil
+	case ChunkedEncryptionHeaderVersion:
+		if err := header.readChunkedFields(r); err != nil {
+			return nil, nil, err
+		}
+		extensionSize := int(header.OffsetInLittleEndian) - encryptionHeaderV2Size
+		if extensionSize < 0 || extensionSize > maxHeaderExtensionSize {
+			return nil, nil, fmt.Errorf("invalid offset %d of encrypted data", header.OffsetInLittleEndian)
+		}
+		extension := make([]byte, extensionSize)
+		if _, err := io.ReadFull(r, extension); err != nil {
+			return nil, nil, fmt.Errorf("encrypted data is truncated: %v", err)
+		}
+		return &header, extension, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported encryption header version %q", version)
+	}
+}
+
+// Write writes the header in the format of the encrypted file
+func (header *EncryptionHeader) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("could not write encryption header: %v", err)
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != AES256KeySize {
+		return nil, fmt.Errorf("invalid key size %d, AES-256 requires a %d byte key", len(key), AES256KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptStream encrypts everything read from src with AES-256-GCM and writes the encryption header followed by
+// the encrypted data to dst
+func EncryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header, err := NewEncryptionHeader()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("could not read data to encrypt: %v", err)
+	}
+	if err = header.Write(dst); err != nil {
+		return err
+	}
+	if _, err = dst.Write(gcm.Seal(nil, header.IV[:], data, nil)); err != nil {
+		return fmt.Errorf("could not write encrypted data: %v", err)
+	}
+	return nil
+}
+
+// DecryptStream decrypts data written by EncryptStream or EncryptChunkedStream from src and writes the plain data
+// to dst. For V1 data nothing is written to dst if the data is truncated or has been tampered with. V2 data is
+// written as the chunks are decrypted, so whatever has been written to dst has to be discarded if an error is
+// returned.
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header, extension, err := readEncryptionHeader(src)
+	if err != nil {
+		return err
+	}
+	if headerField(header.Version[:]) == ChunkedEncryptionHeaderVersion {
+		return decryptChunks(dst, src, gcm, header, extension, defaultWorkers())
+	}
+
+	encData, err := ioutil.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("could not read encrypted data: %v", err)
+	}
+	data, err := gcm.Open(nil, header.IV[:], encData, nil)
+	if err != nil {
+		return fmt.Errorf("could not decrypt data, it is truncated, tampered with or the key is wrong: %v", err)
+	}
+	if _, err = dst.Write(data); err != nil {
+		return fmt.Errorf("could not write decrypted data: %v", err)
+	}
+	return nil
+}
+
+// Encrypt encrypts data with AES-256-GCM and returns it prefixed by the encryption header
+func Encrypt(data, key []byte) ([]byte, error) {
+	var encData bytes.Buffer
+	if err := EncryptStream(&encData, bytes.NewReader(data), key); err != nil {
+		return nil, err
+	}
+	return encData.Bytes(), nil
+}
+
+// Decrypt decrypts data returned by Encrypt or written by EncryptChunkedStream
+func Decrypt(encData, key []byte) ([]byte, error) {
+	var data bytes.Buffer
+	if err := DecryptStream(&data, bytes.NewReader(encData), key); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// transformFile writes the output of transform to dstPath. The output is written to a temporary file in the same
+// directory first, so that dstPath is not left behind partially written and concurrent runs do not interfere
+func transformFile(srcPath, dstPath string, transform func(dst io.Writer, src io.Reader) error) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", srcPath, err)
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(filepath.Dir(dstPath), filepath.Base(dstPath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file for %s: %v", dstPath, err)
+	}
+	tmpPath := dst.Name()
+	if err = transform(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("could not write %s: %v", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, dstPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("could not rename %s to %s: %v", tmpPath, dstPath, err)
+	}
+	return nil
+}
+
+// EncryptFile encrypts the file at plainFilePath with AES-256-GCM and saves it along with the encryption header
+// to encFilePath
+func EncryptFile(plainFilePath, encFilePath string, key []byte) error {
+	return transformFile(plainFilePath, encFilePath, func(dst io.Writer, src io.Reader) error {
+		return EncryptStream(dst, src, key)
+	})
+}
+
+// DecryptFile decrypts the file at encFilePath written by EncryptFile or EncryptChunkedFile and saves the plain
+// data to plainFilePath
+func DecryptFile(encFilePath, plainFilePath string, key []byte) error {
+	return transformFile(encFilePath, plainFilePath, func(dst io.Writer, src io.Reader) error {
+		return DecryptStream(dst, src, key)
+	})
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	// DefaultChunkSize is the amount of plain data encrypted per chunk unless configured otherwise
+	DefaultChunkSize = 1 << 20
+	// MaxChunkSize bounds the chunk size, since workers hold a chunk each in memory
+	MaxChunkSize = 64 << 20
+	// maxHeaderExtensionSize bounds the data between the V2 header and the chunks, like the key envelope
+	maxHeaderExtensionSize = 64 << 10
+)
+
+// EncryptionHeaderV2 is the header of files encrypted in chunks. It extends EncryptionHeader, whose IV is the base
+// the nonces of the chunks are derived from. Each chunk holds up to ChunkSize bytes of plain data followed by the
+// GCM tag. The header may be followed by an extension up to the offset of the data, for instance the key envelope
+// written by EncryptEnvelopeStream. The chunks are authenticated along with the header, the extension and a flag
+// marking the final chunk, so that tampering with the header or reordering, truncating or appending chunks is
+// detected.
+type EncryptionHeaderV2 struct {
+	EncryptionHeader
+	ChunkSize uint32
+	// KeyId names the key the file is encrypted with, for instance the id of the key in a key management service
+	KeyId [64]byte
+}
+
+// encryptionHeaderV2Size is the size of the EncryptionHeaderV2 in the encrypted file
+var encryptionHeaderV2Size = binary.Size(EncryptionHeaderV2{})
+
+// ChunkedEncryptionOptions configures EncryptChunkedStream
+type ChunkedEncryptionOptions struct {
+	// ChunkSize is the amount of plain data per chunk. Defaults to DefaultChunkSize
+	ChunkSize int
+	// KeyId is stored in the header, at most 64 bytes
+	KeyId string
+	// Workers is the number of chunks encrypted in parallel. Defaults to the number of CPUs
+	Workers int
+}
+
+func defaultWorkers() int {
+	return runtime.NumCPU()
+}
+
+// NewEncryptionHeaderV2 returns the header of a file encrypted in chunks of chunkSize with a random base IV
+func NewEncryptionHeaderV2(chunkSize int, keyId string) (*EncryptionHeaderV2, error) {
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d, it has to be between 1 and %d", chunkSize, MaxChunkSize)
+	}
+	base, err := NewEncryptionHeader()
+	if err != nil {
+		return nil, err
+	}
+	header := EncryptionHeaderV2{EncryptionHeader: *base, ChunkSize: uint32(chunkSize)}
+	if len(keyId) > len(header.KeyId) {
+		return nil, fmt.Errorf("key id %q is longer than %d bytes", keyId, len(header.KeyId))
+	}
+	copy(header.KeyId[:], keyId)
+	header.Version = [4]byte{}
+	copy(header.Version[:], ChunkedEncryptionHeaderVersion)
+	header.OffsetInLittleEndian = uint32(encryptionHeaderV2Size)
+	return &header, nil
+}
+
+// readChunkedFields reads the fields following the EncryptionHeader in V2 files
+func (header *EncryptionHeaderV2) readChunkedFields(r io.Reader) error {
+	if err := binary.Read(r, binary.LittleEndian, &header.ChunkSize); err != nil {
+		return fmt.Errorf("could not read encryption header: %v", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header.KeyId); err != nil {
+		return fmt.Errorf("could not read encryption header: %v", err)
+	}
+	if header.ChunkSize == 0 || header.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("invalid chunk size %d in encryption header", header.ChunkSize)
+	}
+	return nil
+}
+
+// Write writes the header in the format of the encrypted file
+func (header *EncryptionHeaderV2) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("could not write encryption header: %v", err)
+	}
+	return nil
+}
+
+// GetKeyId returns the id of the key the file is encrypted with. It is empty for V1 files
+func (header *EncryptionHeaderV2) GetKeyId() string {
+	return headerField(header.KeyId[:])
+}
+
+// nonce derives the nonce of a chunk by xoring the chunk index into the last 8 bytes of the base IV
+func (header *EncryptionHeaderV2) nonce(index uint64) []byte {
+	nonce := make([]byte, len(header.IV))
+	copy(nonce, header.IV[:])
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], index)
+	for i := range counter {
+		nonce[len(nonce)-len(counter)+i] ^= counter[i]
+	}
+	return nonce
+}
+
+// additionalData returns the data authenticated along with a chunk, which is the header and the header extension
+// followed by the final chunk flag
+func (header *EncryptionHeaderV2) additionalData(extension []byte, final bool) []byte {
+	var aad bytes.Buffer
+	binary.Write(&aad, binary.LittleEndian, header)
+	aad.Write(extension)
+	if final {
+		aad.WriteByte(1)
+	} else {
+		aad.WriteByte(0)
+	}
+	return aad.Bytes()
+}
+
+// chunk is a piece of the data passed through the workers. data is replaced by the result
+type chunk struct {
+	index uint64
+	data  []byte
+	final bool
+}
+
+// chunkReader splits a stream into chunks of the same size. It reads a chunk ahead, so that the last chunk of the
+// stream is flagged as final.
+type chunkReader struct {
+	r     io.Reader
+	size  int
+	index uint64
+	next  []byte
+	eof   bool
+	done  bool
+}
+
+func (cr *chunkReader) read() error {
+	buf := make([]byte, cr.size)
+	n, err := io.ReadFull(cr.r, buf)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		cr.eof = true
+	default:
+		return fmt.Errorf("could not read data: %v", err)
+	}
+	cr.next = buf[:n]
+	return nil
+}
+
+// nextChunk returns the next chunk of the stream, or nil after the final chunk has been returned
+func (cr *chunkReader) nextChunk() (*chunk, error) {
+	if cr.done {
+		return nil, nil
+	}
+	if cr.next == nil {
+		if err := cr.read(); err != nil {
+			return nil, err
+		}
+	}
+	c := &chunk{index: cr.index, data: cr.next}
+	cr.index++
+	// the chunk is final if the stream ended while reading it or nothing follows it
+	if !cr.eof {
+		if err := cr.read(); err != nil {
+			return nil, err
+		}
+		if !cr.eof || len(cr.next) > 0 {
+			return c, nil
+		}
+	}
+	c.final = true
+	cr.done = true
+	return c, nil
+}
+
+// processChunks reads batches of up to workers chunks, processes the chunks of a batch in parallel and writes
+// them in order
+func processChunks(dst io.Writer, cr *chunkReader, workers int, process func(c *chunk) error) error {
+	if workers <= 0 {
+		workers = defaultWorkers()
+	}
+	batch := make([]*chunk, 0, workers)
+	errs := make([]error, workers)
+	for {
+		batch = batch[:0]
+		for len(batch) < workers {
+			c, err := cr.nextChunk()
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				break
+			}
+			batch = append(batch, c)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for i, c := range batch {
+			wg.Add(1)
+			go func(i int, c *chunk) {
+				defer wg.Done()
+				errs[i] = process(c)
+			}(i, c)
+		}
+		wg.Wait()
+
+		for i, c := range batch {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if _, err := dst.Write(c.data); err != nil {
+				return fmt.Errorf("could not write data: %v", err)
+			}
+		}
+	}
+}
+
+// EncryptChunkedStream encrypts everything read from src with AES-256-GCM in chunks and writes a V2 encryption
+// header followed by the encrypted chunks to dst. Unlike EncryptStream only a chunk per worker is held in memory,
+// so it is suited for large VM images.
+func EncryptChunkedStream(dst io.Writer, src io.Reader, key []byte, options ...ChunkedEncryptionOptions) error {
+	opts := ChunkedEncryptionOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	return encryptChunks(dst, src, key, opts, nil)
+}
+
+// encryptChunks writes the V2 header followed by the header extension and the encrypted chunks. The extension is
+// authenticated along with the header.
+func encryptChunks(dst io.Writer, src io.Reader, key []byte, opts ChunkedEncryptionOptions, extension []byte) error {
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if len(extension) > maxHeaderExtensionSize {
+		return fmt.Errorf("encryption header extension of %d bytes exceeds %d bytes", len(extension), maxHeaderExtensionSize)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header, err := NewEncryptionHeaderV2(opts.ChunkSize, opts.KeyId)
+	if err != nil {
+		return err
+	}
+	header.OffsetInLittleEndian += uint32(len(extension))
+	if err = header.Write(dst); err != nil {
+		return err
+	}
+	if _, err = dst.Write(extension); err != nil {
+		return fmt.Errorf("could not write encryption header: %v", err)
+	}
+
+	aad := [2][]byte{header.additionalData(extension, false), header.additionalData(extension, true)}
+	cr := &chunkReader{r: src, size: opts.ChunkSize}
+	return processChunks(dst, cr, opts.Workers, func(c *chunk) error {
+		final := 0
+		if c.final {
+			final = 1
+		}
+		c.data = gcm.Seal(nil, header.nonce(c.index), c.data, aad[final])
+		return nil
+	})
+}
+
+// decryptChunks decrypts the chunks following a V2 header and its extension
+func decryptChunks(dst io.Writer, src io.Reader, gcm cipher.AEAD, header *EncryptionHeaderV2, extension []byte, workers int) error {
+	aad := [2][]byte{header.additionalData(extension, false), header.additionalData(extension, true)}
+	cr := &chunkReader{r: src, size: int(header.ChunkSize) + gcm.Overhead()}
+	return processChunks(dst, cr, workers, func(c *chunk) error {
+		final := 0
+		if c.final {
+			final = 1
+		}
+		data, err := gcm.Open(nil, header.nonce(c.index), c.data, aad[final])
+		if err != nil {
+			return fmt.Errorf("could not decrypt chunk %d, the data is truncated, tampered with or the key is wrong: %v", c.index, err)
+		}
+		c.data = data
+		return nil
+	})
+}
+
+// EncryptChunkedFile encrypts the file at plainFilePath in chunks (see EncryptChunkedStream) and saves it to
+// encFilePath
+func EncryptChunkedFile(plainFilePath, encFilePath string, key []byte, options ...ChunkedEncryptionOptions) error {
+	return transformFile(plainFilePath, encFilePath, func(dst io.Writer, src io.Reader) error {
+		return EncryptChunkedStream(dst, src, key, options...)
+	})
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testChunkSize = 16
+
+func encryptChunked(t *testing.T, data, key []byte, keyId string) []byte {
+	var encData bytes.Buffer
+	options := ChunkedEncryptionOptions{ChunkSize: testChunkSize, KeyId: keyId, Workers: 3}
+	if err := EncryptChunkedStream(&encData, bytes.NewReader(data), key, options); err != nil {
+		t.Fatal(err)
+	}
+	return encData.Bytes()
+}
+
+func TestEncryptDecryptChunked(t *testing.T) {
+	key := newTestKey(t)
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 10*testChunkSize + 3} {
+		data, _ := GetRandomBytes(size)
+		encData := encryptChunked(t, data, key, "key-1")
+
+		header, err := ReadEncryptionHeader(bytes.NewReader(encData))
+		assert.NoError(t, err)
+		assert.Equal(t, ChunkedEncryptionHeaderVersion, headerField(header.Version[:]))
+		assert.Equal(t, uint32(testChunkSize), header.ChunkSize)
+		assert.Equal(t, "key-1", header.GetKeyId())
+		chunks := (size + testChunkSize - 1) / testChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		assert.Len(t, encData, encryptionHeaderV2Size+size+chunks*16, "size %d", size)
+
+		decrypted, err := Decrypt(encData, key)
+		assert.NoError(t, err, "size %d", size)
+		assert.Equal(t, len(data), len(decrypted))
+		assert.True(t, bytes.Equal(data, decrypted), "size %d", size)
+	}
+
+	var encData bytes.Buffer
+	assert.Error(t, EncryptChunkedStream(&encData, bytes.NewReader(nil), key, ChunkedEncryptionOptions{ChunkSize: MaxChunkSize + 1}))
+	assert.Error(t, EncryptChunkedStream(&encData, bytes.NewReader(nil), key, ChunkedEncryptionOptions{KeyId: strings.Repeat("k", 65)}))
+}
+
+func TestDecryptChunkedRejectsInvalidData(t *testing.T) {
+	key := newTestKey(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	encData := encryptChunked(t, data, key, "key-1")
+	chunkLen := testChunkSize + 16
+	header := encData[:encryptionHeaderV2Size]
+	chunks := [][]byte{}
+	for rest := encData[encryptionHeaderV2Size:]; len(rest) > 0; rest = rest[chunkLen:] {
+		chunks = append(chunks, rest[:chunkLen])
+	}
+	assert.Len(t, chunks, 4)
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, parts...), nil)
+	}
+	modified := func(offset int) []byte {
+		d := append([]byte{}, encData...)
+		d[offset] ^= 1
+		return d
+	}
+
+	tests := []struct {
+		name    string
+		encData []byte
+	}{
+		{"truncated after chunk", join(chunks[0], chunks[1], chunks[2])},
+		{"chunk removed", join(chunks[0], chunks[2], chunks[3])},
+		{"chunks swapped", join(chunks[1], chunks[0], chunks[2], chunks[3])},
+		{"chunk appended", join(chunks[0], chunks[1], chunks[2], chunks[3], chunks[0])},
+		{"truncated chunk", encData[:len(encData)-1]},
+		{"no chunks", header},
+		{"tampered data", modified(encryptionHeaderV2Size + 1)},
+		{"tampered chunk size", modified(44)},
+		{"tampered key id", modified(49)},
+		{"invalid chunk size", modified(47)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decrypt(test.encData, key)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEncryptDecryptChunkedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := newTestKey(t)
+	plainPath := filepath.Join(dir, "image.qcow2")
+	encPath := filepath.Join(dir, "image.qcow2_enc")
+	decPath := filepath.Join(dir, "image.qcow2_dec")
+	data := bytes.Repeat([]byte("qcow2 image data"), 100000)
+	assert.NoError(t, ioutil.WriteFile(plainPath, data, 0600))
+
+	assert.NoError(t, EncryptChunkedFile(plainPath, encPath, key, ChunkedEncryptionOptions{ChunkSize: 64 << 10}))
+	encrypted, err := EncryptionHeaderExists(encPath)
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+
+	assert.NoError(t, DecryptFile(encPath, decPath, key))
+	decrypted, err := ioutil.ReadFile(decPath)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, decrypted))
+
+	// chunks decrypted before the failure are not left behind
+	encData, _ := ioutil.ReadFile(encPath)
+	assert.NoError(t, ioutil.WriteFile(encPath, encData[:len(encData)-1], 0600))
+	os.Remove(decPath)
+	assert.Error(t, DecryptFile(encPath, decPath, key))
+	_, err = os.Stat(decPath)
+	assert.True(t, os.IsNotExist(err))
+}
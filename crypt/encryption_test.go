@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKey(t *testing.T) []byte {
+	key, err := GetRandomBytes(AES256KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := newTestKey(t)
+	data := []byte("qcow2 image data")
+
+	encData, err := Encrypt(data, key)
+	assert.NoError(t, err)
+	header, err := ReadEncryptionHeader(bytes.NewReader(encData))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(encryptionHeaderSize), header.OffsetInLittleEndian)
+	assert.Equal(t, EncryptionHeaderVersion, headerField(header.Version[:]))
+	assert.Equal(t, GCMEncryptionAlgorithm, headerField(header.EncryptionAlgorithm[:]))
+	assert.NotContains(t, string(encData), string(data))
+
+	decrypted, err := Decrypt(encData, key)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	empty, err := Encrypt(nil, key)
+	assert.NoError(t, err)
+	decrypted, err = Decrypt(empty, key)
+	assert.NoError(t, err)
+	assert.Empty(t, decrypted)
+
+	_, err = Encrypt(data, key[:16])
+	assert.Error(t, err)
+}
+
+func TestDecryptRejectsInvalidData(t *testing.T) {
+	key := newTestKey(t)
+	encData, err := Encrypt([]byte("qcow2 image data"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(modify func(d []byte) []byte) []byte {
+		return modify(append([]byte{}, encData...))
+	}
+
+	tests := []struct {
+		name    string
+		encData []byte
+		key     []byte
+	}{
+		{"wrong key", encData, newTestKey(t)},
+		{"not encrypted", []byte("qcow2 image data that is not encrypted at all"), key},
+		{"truncated header", encData[:encryptionHeaderSize-1], key},
+		{"truncated data", encData[:len(encData)-1], key},
+		{"no data", encData[:encryptionHeaderSize], key},
+		{"tampered data", modified(func(d []byte) []byte { d[len(d)-1] ^= 1; return d }), key},
+		{"tampered IV", modified(func(d []byte) []byte { d[20] ^= 1; return d }), key},
+		{"unknown version", modified(func(d []byte) []byte { d[16] = 'X'; return d }), key},
+		{"unknown algorithm", modified(func(d []byte) []byte { d[32] = 'X'; return d }), key},
+		{"invalid offset", modified(func(d []byte) []byte { d[12] = 1; return d }), key},
+		{"offset beyond data", modified(func(d []byte) []byte { d[13] = 1; return d }), key},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.Error(t, DecryptStream(&out, bytes.NewReader(test.encData), test.key))
+			assert.Zero(t, out.Len())
+		})
+	}
+}
+
+func TestEncryptDecryptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := newTestKey(t)
+	plainPath := filepath.Join(dir, "image.qcow2")
+	encPath := filepath.Join(dir, "image.qcow2_enc")
+	decPath := filepath.Join(dir, "image.qcow2_dec")
+	data := bytes.Repeat([]byte("qcow2 image data"), 1000)
+	assert.NoError(t, ioutil.WriteFile(plainPath, data, 0600))
+
+	assert.NoError(t, EncryptFile(plainPath, encPath, key))
+	encrypted, err := EncryptionHeaderExists(encPath)
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+	encrypted, err = EncryptionHeaderExists(plainPath)
+	assert.NoError(t, err)
+	assert.False(t, encrypted)
+
+	assert.NoError(t, DecryptFile(encPath, decPath, key))
+	decrypted, err := ioutil.ReadFile(decPath)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	// a failed decryption leaves no output behind
+	os.Remove(decPath)
+	assert.Error(t, DecryptFile(encPath, decPath, newTestKey(t)))
+	_, err = os.Stat(decPath)
+	assert.True(t, os.IsNotExist(err))
+	tmpFiles, _ := filepath.Glob(decPath + ".tmp*")
+	assert.Empty(t, tmpFiles)
+	assert.Error(t, DecryptFile(filepath.Join(dir, "missing"), decPath, key))
+}
+
+func TestEncryptFileConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := newTestKey(t)
+	plainPath := filepath.Join(dir, "image.qcow2")
+	encPath := filepath.Join(dir, "image.qcow2_enc")
+	data := bytes.Repeat([]byte("qcow2 image data"), 100000)
+	assert.NoError(t, ioutil.WriteFile(plainPath, data, 0600))
+
+	// runs writing the same destination do not share a temporary file, so the result is one complete file
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = EncryptFile(plainPath, encPath, key)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	encData, err := ioutil.ReadFile(encPath)
+	assert.NoError(t, err)
+	decrypted, err := Decrypt(encData, key)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, decrypted))
+	tmpFiles, _ := filepath.Glob(encPath + ".tmp*")
+	assert.Empty(t, tmpFiles)
+}
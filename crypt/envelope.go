@@ -0,0 +1,320 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// algorithms a data encryption key can be wrapped with
+const (
+	// RSA-OAEP with SHA-256
+	KeyWrapRSAOAEP = "RSA-OAEP-256"
+	// ECDH with an ephemeral P-384 key, the derived key wraps the data encryption key with AES key wrap
+	KeyWrapECDHP384 = "ECDH-ES+A256KW"
+)
+
+// keyWrapIV is the default initial value of AES key wrap (RFC 3394)
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// KeyEnvelope holds the wrapped data encryption key of a file encrypted with EncryptEnvelopeStream. It is stored
+// as json in the extension of the V2 encryption header.
+type KeyEnvelope struct {
+	Algorithm string `json:"alg"`
+	// KeyId is the id of the key encryption key, for instance in the key broker
+	KeyId string `json:"kid,omitempty"`
+	// EphemeralPublicKey is the PKIX der encoded public key of the sender for ECDH
+	EphemeralPublicKey []byte `json:"epk,omitempty"`
+	WrappedKey         []byte `json:"wrapped_key"`
+}
+
+// aesKeyWrap wraps key with kek as specified in RFC 3394
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, fmt.Errorf("key to wrap has to be a multiple of 8 bytes and at least 16 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %v", err)
+	}
+	n := len(key) / 8
+	wrapped := make([]byte, 8+len(key))
+	copy(wrapped[8:], key)
+	a := make([]byte, 8)
+	copy(a, keyWrapIV)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, a)
+			copy(buf[8:], wrapped[i*8:i*8+8])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^uint64(n*j+i))
+			copy(wrapped[i*8:], buf[8:])
+		}
+	}
+	copy(wrapped, a)
+	return wrapped, nil
+}
+
+// aesKeyUnwrap unwraps a key wrapped by aesKeyWrap and checks its integrity
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, fmt.Errorf("wrapped key has an invalid length")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %v", err)
+	}
+	n := len(wrapped)/8 - 1
+	key := make([]byte, len(wrapped)-8)
+	copy(key, wrapped[8:])
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^uint64(n*j+i))
+			copy(buf[8:], key[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(key[(i-1)*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, keyWrapIV) != 1 {
+		return nil, fmt.Errorf("wrapped key failed the integrity check, it has been tampered with or the key is wrong")
+	}
+	return key, nil
+}
+
+// concatKdf derives keyDataLen bits from the shared secret z with the concatenation KDF of NIST SP 800-56A using
+// SHA-256, with the AlgorithmID, PartyUInfo, PartyVInfo and SuppPubInfo fields laid out as in RFC 7518 section 4.6.2
+func concatKdf(z []byte, algorithm string, apu, apv []byte, keyDataLen int) []byte {
+	lengthPrefixed := func(data []byte) []byte {
+		buf := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(buf, uint32(len(data)))
+		copy(buf[4:], data)
+		return buf
+	}
+	otherInfo := lengthPrefixed([]byte(algorithm))
+	otherInfo = append(otherInfo, lengthPrefixed(apu)...)
+	otherInfo = append(otherInfo, lengthPrefixed(apv)...)
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(keyDataLen))
+	otherInfo = append(otherInfo, suppPubInfo...)
+
+	keyLen := keyDataLen / 8
+	key := make([]byte, 0, keyLen+sha256.Size)
+	counter := make([]byte, 4)
+	for round := uint32(1); len(key) < keyLen; round++ {
+		binary.BigEndian.PutUint32(counter, round)
+		kdf := sha256.New()
+		kdf.Write(counter)
+		kdf.Write(z)
+		kdf.Write(otherInfo)
+		key = kdf.Sum(key)
+	}
+	return key[:keyLen]
+}
+
+// ecdhKek derives the key encryption key from the shared secret of an ECDH key agreement as specified for
+// ECDH-ES+A256KW in RFC 7518 section 4.6, without PartyUInfo and PartyVInfo
+func ecdhKek(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
+	if priv.Curve != elliptic.P384() || pub.Curve != elliptic.P384() || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("ECDH key wrapping requires P-384 keys")
+	}
+	x, _ := pub.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	secret := make([]byte, (pub.Curve.Params().BitSize+7)/8)
+	xBytes := x.Bytes()
+	copy(secret[len(secret)-len(xBytes):], xBytes)
+	return concatKdf(secret, KeyWrapECDHP384, nil, nil, AES256KeySize*8), nil
+}
+
+// WrapKey wraps the data encryption key with the public key. RSA public keys use RSA-OAEP, P-384 ECDSA public keys
+// use ECDH with an ephemeral key and AES key wrap.
+func WrapKey(dek []byte, pubKey crypto.PublicKey, keyId string) (*KeyEnvelope, error) {
+	switch pub := pubKey.(type) {
+	case *rsa.PublicKey:
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, dek, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not wrap key with RSA-OAEP: %v", err)
+		}
+		return &KeyEnvelope{Algorithm: KeyWrapRSAOAEP, KeyId: keyId, WrappedKey: wrapped}, nil
+	case *ecdsa.PublicKey:
+		ephemeral, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate ephemeral key: %v", err)
+		}
+		kek, err := ecdhKek(ephemeral, pub)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := aesKeyWrap(kek, dek)
+		if err != nil {
+			return nil, err
+		}
+		epk, err := x509.MarshalPKIXPublicKey(&ephemeral.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal ephemeral public key: %v", err)
+		}
+		return &KeyEnvelope{Algorithm: KeyWrapECDHP384, KeyId: keyId, EphemeralPublicKey: epk, WrappedKey: wrapped}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T for key wrapping, only RSA and ECDSA P-384 supported", pubKey)
+	}
+}
+
+// UnwrapKey returns the data encryption key in the envelope, unwrapped with the private key
+func UnwrapKey(envelope *KeyEnvelope, privKey crypto.PrivateKey) ([]byte, error) {
+	switch envelope.Algorithm {
+	case KeyWrapRSAOAEP:
+		priv, ok := privKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA private key", envelope.Algorithm)
+		}
+		dek, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, envelope.WrappedKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not unwrap key with RSA-OAEP: %v", err)
+		}
+		return dek, nil
+	case KeyWrapECDHP384:
+		priv, ok := privKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an ECDSA private key", envelope.Algorithm)
+		}
+		epk, err := x509.ParsePKIXPublicKey(envelope.EphemeralPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ephemeral public key: %v", err)
+		}
+		ephemeral, ok := epk.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("ephemeral public key is not an ECDSA key")
+		}
+		kek, err := ecdhKek(priv, ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		return aesKeyUnwrap(kek, envelope.WrappedKey)
+	default:
+		return nil, fmt.Errorf("unsupported key wrapping algorithm %q", envelope.Algorithm)
+	}
+}
+
+// EncryptEnvelopeStream generates a random data encryption key, encrypts everything read from src with it in
+// chunks (see EncryptChunkedStream) and stores the key wrapped with pubKey in the encryption header. keyId is the
+// id of the key pair and is stored in the header as well, so that the private key can be looked up for decryption.
+func EncryptEnvelopeStream(dst io.Writer, src io.Reader, pubKey crypto.PublicKey, keyId string, options ...ChunkedEncryptionOptions) error {
+	opts := ChunkedEncryptionOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts.KeyId = keyId
+
+	dek, err := GetRandomBytes(AES256KeySize)
+	if err != nil {
+		return fmt.Errorf("could not generate data encryption key: %v", err)
+	}
+	defer zeroBytes(dek)
+	envelope, err := WrapKey(dek, pubKey, keyId)
+	if err != nil {
+		return err
+	}
+	envelopeJson, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("could not marshal key envelope: %v", err)
+	}
+	return encryptChunks(dst, src, dek, opts, envelopeJson)
+}
+
+// ReadKeyEnvelope reads the encryption header from r and returns it along with the key envelope. r is positioned
+// at the start of the encrypted data afterwards.
+func ReadKeyEnvelope(r io.Reader) (*EncryptionHeaderV2, *KeyEnvelope, error) {
+	header, extension, err := readEncryptionHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	envelope, err := parseKeyEnvelope(extension)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, envelope, nil
+}
+
+func parseKeyEnvelope(extension []byte) (*KeyEnvelope, error) {
+	if len(extension) == 0 {
+		return nil, fmt.Errorf("encryption header does not contain a key envelope")
+	}
+	envelope := KeyEnvelope{}
+	if err := json.Unmarshal(extension, &envelope); err != nil {
+		return nil, fmt.Errorf("could not parse key envelope: %v", err)
+	}
+	return &envelope, nil
+}
+
+// DecryptEnvelopeStream decrypts data written by EncryptEnvelopeStream from src with the data encryption key
+// unwrapped by privKey, and writes the plain data to dst. Like for DecryptStream, whatever has been written to dst
+// has to be discarded if an error is returned.
+func DecryptEnvelopeStream(dst io.Writer, src io.Reader, privKey crypto.PrivateKey) error {
+	header, extension, err := readEncryptionHeader(src)
+	if err != nil {
+		return err
+	}
+	envelope, err := parseKeyEnvelope(extension)
+	if err != nil {
+		return err
+	}
+	dek, err := UnwrapKey(envelope, privKey)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(dek)
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+	return decryptChunks(dst, src, gcm, header, extension, defaultWorkers())
+}
+
+// EncryptEnvelopeFile encrypts the file at plainFilePath with a data encryption key wrapped by pubKey (see
+// EncryptEnvelopeStream) and saves it to encFilePath
+func EncryptEnvelopeFile(plainFilePath, encFilePath string, pubKey crypto.PublicKey, keyId string, options ...ChunkedEncryptionOptions) error {
+	return transformFile(plainFilePath, encFilePath, func(dst io.Writer, src io.Reader) error {
+		return EncryptEnvelopeStream(dst, src, pubKey, keyId, options...)
+	})
+}
+
+// DecryptEnvelopeFile decrypts the file at encFilePath written by EncryptEnvelopeFile with the plain PKCS8 private
+// key at privKeyPath and saves the plain data to plainFilePath
+func DecryptEnvelopeFile(encFilePath, plainFilePath, privKeyPath string) error {
+	return DecryptEnvelopeFileWithPassword(encFilePath, plainFilePath, privKeyPath, nil)
+}
+
+// DecryptEnvelopeFileWithPassword is DecryptEnvelopeFile for a private key saved with
+// SaveEncryptedPrivateKeyAsPKCS8, which is decrypted with keyPassword
+func DecryptEnvelopeFileWithPassword(encFilePath, plainFilePath, privKeyPath string, keyPassword []byte) error {
+	privKey, err := GetPrivateKeyFromPKCS8FileWithPassword(privKeyPath, keyPassword)
+	if err != nil {
+		return err
+	}
+	return transformFile(encFilePath, plainFilePath, func(dst io.Writer, src io.Reader) error {
+		return DecryptEnvelopeStream(dst, src, privKey)
+	})
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAesKeyWrap(t *testing.T) {
+	// RFC 3394 4.6, 256 bits of key data with a 256-bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	wrapped, err := aesKeyWrap(kek, key)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, wrapped)
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrapped)
+
+	wrapped[10] ^= 1
+	_, err = aesKeyUnwrap(kek, wrapped)
+	assert.Error(t, err)
+	_, err = aesKeyUnwrap(kek, wrapped[:16])
+	assert.Error(t, err)
+}
+
+func generateTestKeyPair(t *testing.T, keyType string, keyLength int) (crypto.PrivateKey, crypto.PublicKey) {
+	privKey, pubKey, err := GenerateKeyPair(keyType, keyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privKey, pubKey
+}
+
+func TestConcatKdf(t *testing.T) {
+	// RFC 7518 appendix C, ECDH-ES with P-256 keys deriving an A128GCM key
+	z := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	expected := []byte{86, 170, 141, 234, 248, 35, 109, 32, 92, 34, 40, 205, 113, 167, 16, 26}
+
+	key := concatKdf(z, "A128GCM", []byte("Alice"), []byte("Bob"), 128)
+	assert.Equal(t, expected, key)
+
+	// a key longer than the digest takes more than one round
+	key = concatKdf(z, "A128GCM", []byte("Alice"), []byte("Bob"), 384)
+	assert.Len(t, key, 48)
+}
+
+func TestWrapUnwrapKey(t *testing.T) {
+	dek := newTestKey(t)
+	rsaPriv, rsaPub := generateTestKeyPair(t, "rsa", 2048)
+	ecPriv, ecPub := generateTestKeyPair(t, "ecdsa", 384)
+	otherEcPriv, _ := generateTestKeyPair(t, "ecdsa", 384)
+	_, p521Pub := generateTestKeyPair(t, "ecdsa", 521)
+
+	envelope, err := WrapKey(dek, rsaPub, "rsa-key")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyWrapRSAOAEP, envelope.Algorithm)
+	unwrapped, err := UnwrapKey(envelope, rsaPriv)
+	assert.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+	_, err = UnwrapKey(envelope, ecPriv)
+	assert.Error(t, err)
+
+	envelope, err = WrapKey(dek, ecPub, "ec-key")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyWrapECDHP384, envelope.Algorithm)
+	assert.NotEmpty(t, envelope.EphemeralPublicKey)
+	unwrapped, err = UnwrapKey(envelope, ecPriv)
+	assert.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+	_, err = UnwrapKey(envelope, otherEcPriv)
+	assert.Error(t, err)
+	_, err = UnwrapKey(envelope, rsaPriv)
+	assert.Error(t, err)
+
+	_, err = WrapKey(dek, p521Pub, "p521-key")
+	assert.Error(t, err)
+	_, err = UnwrapKey(&KeyEnvelope{Algorithm: "A256KW"}, ecPriv)
+	assert.Error(t, err)
+}
+
+func TestEncryptDecryptEnvelope(t *testing.T) {
+	ecPriv, ecPub := generateTestKeyPair(t, "ecdsa", 384)
+	rsaPriv, rsaPub := generateTestKeyPair(t, "rsa", 2048)
+	data := bytes.Repeat([]byte("qcow2 image data"), 10)
+	options := ChunkedEncryptionOptions{ChunkSize: testChunkSize}
+
+	for keyId, keyPair := range map[string][2]interface{}{"ec-key": {ecPriv, ecPub}, "rsa-key": {rsaPriv, rsaPub}} {
+		var encData bytes.Buffer
+		assert.NoError(t, EncryptEnvelopeStream(&encData, bytes.NewReader(data), keyPair[1], keyId, options))
+
+		header, envelope, err := ReadKeyEnvelope(bytes.NewReader(encData.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, keyId, header.GetKeyId())
+		assert.Equal(t, keyId, envelope.KeyId)
+
+		var decrypted bytes.Buffer
+		assert.NoError(t, DecryptEnvelopeStream(&decrypted, bytes.NewReader(encData.Bytes()), keyPair[0]))
+		assert.Equal(t, data, decrypted.Bytes())
+
+		// the envelope is authenticated along with the header
+		tampered := append([]byte{}, encData.Bytes()...)
+		tampered[encryptionHeaderV2Size+2] = ' '
+		assert.Error(t, DecryptEnvelopeStream(&decrypted, bytes.NewReader(tampered), keyPair[0]))
+	}
+
+	var encData bytes.Buffer
+	assert.NoError(t, EncryptChunkedStream(&encData, bytes.NewReader(data), newTestKey(t)))
+	_, _, err := ReadKeyEnvelope(bytes.NewReader(encData.Bytes()))
+	assert.Error(t, err)
+	assert.Error(t, DecryptEnvelopeStream(&bytes.Buffer{}, bytes.NewReader(encData.Bytes()), ecPriv))
+}
+
+func TestEncryptDecryptEnvelopeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envelope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	privKey, pubKey := generateTestKeyPair(t, "ecdsa", 384)
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(dir, "key.pem")
+	assert.NoError(t, SavePrivateKeyAsPKCS8(pkcs8Der, keyPath))
+
+	plainPath := filepath.Join(dir, "image.qcow2")
+	encPath := filepath.Join(dir, "image.qcow2_enc")
+	decPath := filepath.Join(dir, "image.qcow2_dec")
+	data := bytes.Repeat([]byte("qcow2 image data"), 1000)
+	assert.NoError(t, ioutil.WriteFile(plainPath, data, 0600))
+
+	assert.NoError(t, EncryptEnvelopeFile(plainPath, encPath, pubKey, "image-key"))
+	assert.NoError(t, DecryptEnvelopeFile(encPath, decPath, keyPath))
+	decrypted, err := ioutil.ReadFile(decPath)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+	assert.Error(t, DecryptEnvelopeFile(encPath, decPath, filepath.Join(dir, "missing.pem")))
+
+	encKeyPath := filepath.Join(dir, "enc-key.pem")
+	assert.NoError(t, SaveEncryptedPrivateKeyAsPKCS8(pkcs8Der, encKeyPath, []byte("password")))
+	assert.Error(t, DecryptEnvelopeFile(encPath, decPath, encKeyPath))
+	assert.NoError(t, DecryptEnvelopeFileWithPassword(encPath, decPath, encKeyPath, []byte("password")))
+}
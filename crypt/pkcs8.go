@@ -0,0 +1,257 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"os"
+)
+
+const (
+	// EncryptedPrivateKeyPemType is the pem block type of encrypted PKCS8 private keys (RFC 5958)
+	EncryptedPrivateKeyPemType = "ENCRYPTED PRIVATE KEY"
+	// PBKDF2Iterations is the iteration count used when encrypting private keys
+	PBKDF2Iterations = 600000
+	// maxPBKDF2Iterations bounds the iteration count accepted when decrypting
+	maxPBKDF2Iterations = 10000000
+	pbkdf2SaltSize      = 16
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES256GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+)
+
+// encryptedPrivateKeyInfo is the EncryptedPrivateKeyInfo of RFC 5958
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the PBES2-params of RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PBKDF2-params of RFC 8018. The prf defaults to hmacWithSHA1 when it is absent
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// gcmParams are the GCMParameters of RFC 5084
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// pbkdf2Key derives a key from the password as specified in RFC 8018
+func pbkdf2Key(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var blockIndex [4]byte
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex[:], uint32(block))
+		prf.Write(blockIndex[:])
+		key = prf.Sum(key)
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
+
+// EncryptPKCS8PrivateKey encrypts the der encoded PKCS8 private key with the password and returns the der encoded
+// EncryptedPrivateKeyInfo. The key is encrypted with PBES2, using PBKDF2 with HMAC-SHA256 and AES-256-CBC, which
+// is what "openssl pkcs8 -topk8 -v2 aes-256-cbc" produces as well.
+func EncryptPKCS8PrivateKey(pkcs8Der, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, fmt.Errorf("password to encrypt the private key is empty")
+	}
+	salt, err := GetRandomBytes(pbkdf2SaltSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate salt: %v", err)
+	}
+	iv, err := GetRandomBytes(aes.BlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate IV: %v", err)
+	}
+
+	key := pbkdf2Key(password, salt, PBKDF2Iterations, AES256KeySize, sha256.New)
+	defer zeroBytes(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %v", err)
+	}
+	// PKCS7 padding
+	padding := aes.BlockSize - len(pkcs8Der)%aes.BlockSize
+	encData := append(append([]byte{}, pkcs8Der...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encData, encData)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: PBKDF2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encData,
+	})
+}
+
+// DecryptPKCS8PrivateKey decrypts the der encoded EncryptedPrivateKeyInfo with the password and returns the der
+// encoded PKCS8 private key. PBES2 with PBKDF2 (HMAC-SHA1 or HMAC-SHA256) and AES-256-CBC or AES-256-GCM is
+// supported.
+func DecryptPKCS8PrivateKey(encDer, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(encDer, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("could not parse encrypted private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption algorithm %v, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("could not parse PBES2 parameters: %v", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %v, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("could not parse PBKDF2 parameters: %v", err)
+	}
+	if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", kdfParams.IterationCount)
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != AES256KeySize {
+		return nil, fmt.Errorf("invalid PBKDF2 key length %d", kdfParams.KeyLength)
+	}
+	prf := sha1.New
+	switch {
+	case kdfParams.PRF.Algorithm == nil || kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudo random function %v", kdfParams.PRF.Algorithm)
+	}
+	key := pbkdf2Key(password, kdfParams.Salt, kdfParams.IterationCount, AES256KeySize, prf)
+	defer zeroBytes(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %v", err)
+	}
+
+	decryptErr := fmt.Errorf("could not decrypt private key, the password is wrong or the key is corrupt")
+	scheme := params.EncryptionScheme
+	switch {
+	case scheme.Algorithm.Equal(oidAES256CBC):
+		var iv []byte
+		if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+			return nil, fmt.Errorf("invalid AES-256-CBC IV")
+		}
+		encData := info.EncryptedData
+		if len(encData) == 0 || len(encData)%aes.BlockSize != 0 {
+			return nil, decryptErr
+		}
+		data := make([]byte, len(encData))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, encData)
+		padding := int(data[len(data)-1])
+		if padding == 0 || padding > aes.BlockSize ||
+			!bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			return nil, decryptErr
+		}
+		return data[:len(data)-padding], nil
+	case scheme.Algorithm.Equal(oidAES256GCM):
+		var gcmParameters gcmParams
+		if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &gcmParameters); err != nil {
+			return nil, fmt.Errorf("invalid AES-256-GCM parameters")
+		}
+		// the tag length defaults to 12 bytes (RFC 5084), cipher.NewGCMWithTagSize accepts 12 to 16 bytes with the
+		// standard nonce size
+		if len(gcmParameters.Nonce) != 12 {
+			return nil, fmt.Errorf("unsupported AES-256-GCM parameters")
+		}
+		gcm, err := cipher.NewGCMWithTagSize(block, gcmParameters.ICVLen)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported AES-256-GCM parameters")
+		}
+		data, err := gcm.Open(nil, gcmParameters.Nonce, info.EncryptedData, nil)
+		if err != nil {
+			return nil, decryptErr
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key encryption scheme %v", scheme.Algorithm)
+	}
+}
+
+// SaveEncryptedPrivateKeyAsPKCS8 encrypts the der encoded PKCS8 private key with the password (see
+// EncryptPKCS8PrivateKey) and saves it pem encoded to filePath
+func SaveEncryptedPrivateKeyAsPKCS8(keyDer []byte, filePath string, password []byte) error {
+	encDer, err := EncryptPKCS8PrivateKey(keyDer, password)
+	if err != nil {
+		return fmt.Errorf("could not encrypt private key: %v", err)
+	}
+
+	keyOut, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0) // open file with restricted permissions
+	if err != nil {
+		return fmt.Errorf("could not open private key file for writing: %v", err)
+	}
+	// private key should not be world readable
+	os.Chmod(filePath, 0640)
+	defer keyOut.Close()
+
+	if err := pem.Encode(keyOut, &pem.Block{Type: EncryptedPrivateKeyPemType, Bytes: encDer}); err != nil {
+		return fmt.Errorf("could not pem encode the private key: %v", err)
+	}
+	return nil
+}
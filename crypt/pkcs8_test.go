@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPbkdf2Key(t *testing.T) {
+	// RFC 7914 section 11, PBKDF2-HMAC-SHA256
+	expected, _ := hex.DecodeString("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	assert.Equal(t, expected, pbkdf2Key([]byte("passwd"), []byte("salt"), 1, 64, sha256.New))
+	expected, _ = hex.DecodeString("4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+		"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d")
+	assert.Equal(t, expected, pbkdf2Key([]byte("Password"), []byte("NaCl"), 80000, 64, sha256.New))
+}
+
+func newTestPKCS8Key(t *testing.T) []byte {
+	privKey, _, err := GenerateKeyPair("ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkcs8Der
+}
+
+func TestEncryptDecryptPKCS8PrivateKey(t *testing.T) {
+	pkcs8Der := newTestPKCS8Key(t)
+
+	encDer, err := EncryptPKCS8PrivateKey(pkcs8Der, []byte("password"))
+	assert.NoError(t, err)
+	decrypted, err := DecryptPKCS8PrivateKey(encDer, []byte("password"))
+	assert.NoError(t, err)
+	assert.Equal(t, pkcs8Der, decrypted)
+
+	_, err = DecryptPKCS8PrivateKey(encDer, []byte("wrong password"))
+	assert.Error(t, err)
+	_, err = DecryptPKCS8PrivateKey(pkcs8Der, []byte("password"))
+	assert.Error(t, err)
+	_, err = EncryptPKCS8PrivateKey(pkcs8Der, nil)
+	assert.Error(t, err)
+}
+
+func TestDecryptPKCS8PrivateKeyGCM(t *testing.T) {
+	pkcs8Der := newTestPKCS8Key(t)
+	salt, _ := GetRandomBytes(pbkdf2SaltSize)
+	nonce, _ := GetRandomBytes(12)
+	key := pbkdf2Key([]byte("password"), salt, 1000, AES256KeySize, sha256.New)
+	block, _ := aes.NewCipher(key)
+
+	kdfParams, _ := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: 1000,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	// the default ICV length of 12 bytes is omitted from the parameters
+	for _, icvLen := range []int{12, 16} {
+		gcm, _ := cipher.NewGCMWithTagSize(block, icvLen)
+		schemeParams, _ := asn1.Marshal(gcmParams{Nonce: nonce, ICVLen: icvLen})
+		params, _ := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+			EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		})
+		encDer, _ := asn1.Marshal(encryptedPrivateKeyInfo{
+			Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedData: gcm.Seal(nil, nonce, pkcs8Der, nil),
+		})
+
+		decrypted, err := DecryptPKCS8PrivateKey(encDer, []byte("password"))
+		assert.NoError(t, err)
+		assert.Equal(t, pkcs8Der, decrypted)
+		_, err = DecryptPKCS8PrivateKey(encDer, []byte("wrong password"))
+		assert.Error(t, err)
+	}
+}
+
+func TestLoadEncryptedPrivateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkcs8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certDer, pkcs8Der, err := CreateKeyPairAndCertificate("test", "", "ecdsa", 384)
+	assert.NoError(t, err)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	plainKeyPath := filepath.Join(dir, "plain-key.pem")
+	assert.NoError(t, SavePemCert(certDer, certPath))
+	assert.NoError(t, SaveEncryptedPrivateKeyAsPKCS8(pkcs8Der, keyPath, []byte("password")))
+	assert.NoError(t, SavePrivateKeyAsPKCS8(pkcs8Der, plainKeyPath))
+
+	keyPem, _ := ioutil.ReadFile(keyPath)
+	block, _ := pem.Decode(keyPem)
+	assert.Equal(t, EncryptedPrivateKeyPemType, block.Type)
+
+	cert, key, err := LoadX509CertAndPrivateKeyWithPassword(certPath, keyPath, []byte("password"))
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+	assert.NotNil(t, key)
+	// encrypted keys are only loaded by the WithPassword variants
+	_, _, err = LoadX509CertAndPrivateKey(certPath, keyPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "has to be loaded with a password")
+	}
+	_, _, err = LoadX509CertAndPrivateKeyWithPassword(certPath, keyPath, []byte("wrong password"))
+	assert.Error(t, err)
+
+	// the password is ignored for keys that are not encrypted
+	_, key, err = LoadX509CertAndPrivateKeyWithPassword(certPath, plainKeyPath, []byte("password"))
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+}
@@ -7,6 +7,7 @@ package crypt
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -36,7 +37,14 @@ func GenerateKeyPair(keyType string, keyLength int) (crypto.PrivateKey, crypto.P
 			return nil, nil, fmt.Errorf("could not generate rsa key pair Error: %s", err)
 		}
 		return k, &k.PublicKey, nil
-	// if the keytype is not "rsa", then we will always use ecdsa as this is the preferred
+	case "ed25519", "eddsa":
+		// ed25519 keys have a fixed size, so the keyLength is ignored
+		pub, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not generate ed25519 key pair Error: %s", err)
+		}
+		return k, pub, nil
+	// if the keytype is not "rsa" or "ed25519", then we will always use ecdsa as this is the preferred
 	//
 	default:
 		keyCurve := elliptic.P384()
@@ -75,8 +83,10 @@ func GetSignatureAlgorithm(pubKey crypto.PublicKey) (x509.SignatureAlgorithm, er
 			return x509.UnknownSignatureAlgorithm, fmt.Errorf("upsupported signature algorithm for certificate with ecdsa keys. only sha 384, 512 supported")
 
 		}
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
 	default:
-		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported public key type when generating certificate request. Only rsa and ecdsa supported")
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported public key type when generating certificate request. Only rsa, ecdsa and ed25519 supported")
 	}
 }
 
@@ -85,10 +95,25 @@ func GetSignatureAlgorithm(pubKey crypto.PublicKey) (x509.SignatureAlgorithm, er
 func CreateKeyPairAndCertificateRequest(subject pkix.Name, hostList, keyType string, keyLength int) (certReq []byte, pkcs8Der []byte, err error) {
 
 	//first let us look at type of keypair that we are generating
-	privKey, pubKey, err := GenerateKeyPair(keyType, keyLength)
+	privKey, _, err := GenerateKeyPair(keyType, keyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certReq, err = CreateCertificateRequest(subject, hostList, privKey.(crypto.Signer))
 	if err != nil {
 		return nil, nil, err
 	}
+	pkcs8Der, err = x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not marshal private key to pkcs8 format error :%s", err)
+	}
+	return certReq, pkcs8Der, nil
+}
+
+// CreateCertificateRequest returns the der bytes of a CSR for the key of signer, which may be a key from a
+// keystore.KeyStore that is not held in memory
+func CreateCertificateRequest(subject pkix.Name, hostList string, signer crypto.Signer) ([]byte, error) {
 
 	template := x509.CertificateRequest{
 		Subject: pkix.Name{
@@ -99,9 +124,10 @@ func CreateKeyPairAndCertificateRequest(subject pkix.Name, hostList, keyType str
 			Locality:     subject.Locality,
 		},
 	}
-	template.SignatureAlgorithm, err = GetSignatureAlgorithm(pubKey)
+	var err error
+	template.SignatureAlgorithm, err = GetSignatureAlgorithm(signer.Public())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	hosts := strings.Split(hostList, ",")
@@ -114,15 +140,11 @@ func CreateKeyPairAndCertificateRequest(subject pkix.Name, hostList, keyType str
 		}
 	}
 
-	certReq, err = x509.CreateCertificateRequest(rand.Reader, &template, privKey)
+	certReq, err := x509.CreateCertificateRequest(rand.Reader, &template, signer)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Could not create certificate request. error : %s", err)
-	}
-	pkcs8Der, err = x509.MarshalPKCS8PrivateKey(privKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Could not marshal private key to pkcs8 format error :%s", err)
+		return nil, fmt.Errorf("Could not create certificate request. error : %s", err)
 	}
-	return certReq, pkcs8Der, nil
+	return certReq, nil
 }
 
 // CreateKeyPairAndCertificate takes in parameters for certificate and return der bytes for the certificate
@@ -180,7 +202,7 @@ func CreateKeyPairAndCertificate(subject, hostList, keyType string, keyLength in
 }
 
 // GetPublicKeyFromCert retrieve the public key from a certificate
-// We only support ECDSA and RSA public key
+// We only support ECDSA, RSA and Ed25519 public key
 func GetPublicKeyFromCert(cert *x509.Certificate) (crypto.PublicKey, error) {
 	switch cert.PublicKeyAlgorithm {
 	case x509.RSA:
@@ -193,12 +215,17 @@ func GetPublicKeyFromCert(cert *x509.Certificate) (crypto.PublicKey, error) {
 			return key, nil
 		}
 		return nil, fmt.Errorf("public key algorithm of cert reported as ECDSA cert does not match ECDSA public key struct")
+	case x509.Ed25519:
+		if key, ok := cert.PublicKey.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("public key algorithm of cert reported as Ed25519 cert does not match Ed25519 public key struct")
 	}
-	return nil, fmt.Errorf("only RSA and ECDSA public keys are supported")
+	return nil, fmt.Errorf("only RSA, ECDSA and Ed25519 public keys are supported")
 }
 
 // GetPublicKeyFromCertPem retrieve the public key from a certificate pem block
-// We only support ECDSA and RSA public key
+// We only support ECDSA, RSA and Ed25519 public key
 func GetPublicKeyFromCertPem(certPem []byte) (crypto.PublicKey, error) {
 	cert, err := GetCertFromPem(certPem)
 	if err != nil {
@@ -236,6 +263,26 @@ func GetCertAndChainFromPem(certPem []byte) (cert *x509.Certificate, chain *x509
 	return cert, nil, nil
 }
 
+// GetCertChainFromPem returns all the certificates in a pem block in the order they appear. The first
+// certificate is expected to be the leaf certificate followed by the intermediates
+func GetCertChainFromPem(certPem []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(certPem); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+	return certs, nil
+}
+
 func GetCertFromPemFile(path string) (*x509.Certificate, error) {
 	certPem, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -281,7 +328,15 @@ func SavePrivateKeyAsPKCS8(keyDer []byte, filePath string) error {
 
 }
 
+// GetPKCS8PrivKeyDerFromFile returns the der encoded PKCS8 private key in the file. Encrypted keys are not
+// supported, use GetPKCS8PrivKeyDerFromFileWithPassword for keys saved with SaveEncryptedPrivateKeyAsPKCS8.
 func GetPKCS8PrivKeyDerFromFile(path string) ([]byte, error) {
+	return GetPKCS8PrivKeyDerFromFileWithPassword(path, nil)
+}
+
+// GetPKCS8PrivKeyDerFromFileWithPassword returns the der encoded PKCS8 private key in the file. Keys saved with
+// SaveEncryptedPrivateKeyAsPKCS8 are decrypted with the keyPassword, which is ignored for plain keys.
+func GetPKCS8PrivKeyDerFromFileWithPassword(path string, keyPassword []byte) ([]byte, error) {
 
 	privKeyPem, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -289,6 +344,12 @@ func GetPKCS8PrivKeyDerFromFile(path string) ([]byte, error) {
 	}
 
 	block, _ := pem.Decode(privKeyPem)
+	if block != nil && block.Type == EncryptedPrivateKeyPemType {
+		if len(keyPassword) == 0 {
+			return nil, fmt.Errorf("private key in %s is encrypted, it has to be loaded with a password", path)
+		}
+		return DecryptPKCS8PrivateKey(block.Bytes, keyPassword)
+	}
 	if block == nil || block.Type != "PKCS8 PRIVATE KEY" {
 		return nil, fmt.Errorf("failed to parse private Key PEM file")
 	}
@@ -296,8 +357,16 @@ func GetPKCS8PrivKeyDerFromFile(path string) ([]byte, error) {
 	return block.Bytes, nil
 }
 
+// GetPrivateKeyFromPKCS8File returns the private key in the file. Encrypted keys are not supported, use
+// GetPrivateKeyFromPKCS8FileWithPassword for keys saved with SaveEncryptedPrivateKeyAsPKCS8.
 func GetPrivateKeyFromPKCS8File(path string) (interface{}, error) {
-	privKeyDer, err := GetPKCS8PrivKeyDerFromFile(path)
+	return GetPrivateKeyFromPKCS8FileWithPassword(path, nil)
+}
+
+// GetPrivateKeyFromPKCS8FileWithPassword returns the private key in the file, see
+// GetPKCS8PrivKeyDerFromFileWithPassword for keyPassword
+func GetPrivateKeyFromPKCS8FileWithPassword(path string, keyPassword []byte) (interface{}, error) {
+	privKeyDer, err := GetPKCS8PrivKeyDerFromFileWithPassword(path, keyPassword)
 	if err != nil {
 		return nil, fmt.Errorf("could not get private key from file - err: %v", err)
 	}
@@ -309,12 +378,21 @@ func GetPrivateKeyFromPKCS8File(path string) (interface{}, error) {
 
 }
 
+// LoadX509CertAndPrivateKey loads the certificate at cp and the private key at kp. Encrypted private keys, such as
+// the ones setup.Download_Cert saves when KeyPassword is set, are not supported; they have to be loaded with
+// LoadX509CertAndPrivateKeyWithPassword.
 func LoadX509CertAndPrivateKey(cp, kp string) (*x509.Certificate, interface{}, error) {
+	return LoadX509CertAndPrivateKeyWithPassword(cp, kp, nil)
+}
+
+// LoadX509CertAndPrivateKeyWithPassword loads the certificate at cp and the private key at kp. Encrypted private
+// keys are decrypted with the keyPassword
+func LoadX509CertAndPrivateKeyWithPassword(cp, kp string, keyPassword []byte) (*x509.Certificate, interface{}, error) {
 	cert, err := GetCertFromPemFile(cp)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not load certificate. err: %v", err)
 	}
-	key, err := GetPrivateKeyFromPKCS8File(kp)
+	key, err := GetPrivateKeyFromPKCS8FileWithPassword(kp, keyPassword)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not load private key. err: %v", err)
 	}
@@ -1,8 +1,12 @@
 module intel/isecl/lib/common/v2
 
+go 1.13
+
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.7.3
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.4.0
 	github.com/stretchr/testify v1.2.2
 	gopkg.in/yaml.v2 v2.2.2
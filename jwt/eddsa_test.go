@@ -0,0 +1,41 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/pem"
+	"intel/isecl/lib/common/v2/crypt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEdDSATokens(t *testing.T) {
+	cert, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("jwt signing", "", "ed25519", 0)
+	assert.NoError(t, err)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+
+	factory, err := NewTokenFactory(pkcs8Der, true, certPem, "AAS JWT Issuer", 0)
+	assert.NoError(t, err)
+	tokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	verifier, err := NewVerifier(certPem, nil, time.Hour, VerifyOptions{AllowedAlgorithms: []string{"EdDSA"}})
+	assert.NoError(t, err)
+	claims := testClaims{}
+	token, err := verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "EdDSA", (*token.GetHeader())["alg"])
+	assert.Equal(t, "admin", claims.Name)
+
+	jwks, err := factory.GetJwks()
+	assert.NoError(t, err)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[0].Curve)
+	pubKey, err := jwks.Keys[0].PublicKey()
+	assert.NoError(t, err)
+	assert.True(t, publicKeysEqual(pubKey, factory.signingCerts[0].PublicKey))
+}
@@ -0,0 +1,409 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMinRefreshInterval limits how often a token with an unknown kid can trigger a new download of the
+	// JWKS document. Without this, anyone could make us hammer the issuer by sending tokens with random kids.
+	jwksMinRefreshInterval time.Duration = 30 * time.Second
+	jwksMaxDocumentSize    int64         = 1 << 20
+	jwksHttpTimeout        time.Duration = 30 * time.Second
+)
+
+// JSONWebKey is the RFC 7517 representation of a public key that can be used to verify a JWT.
+// Only the members needed for RSA, EC and Ed25519 (OKP) signature keys are supported.
+type JSONWebKey struct {
+	KeyType   string   `json:"kty"`
+	Use       string   `json:"use,omitempty"`
+	KeyId     string   `json:"kid,omitempty"`
+	Algorithm string   `json:"alg,omitempty"`
+	N         string   `json:"n,omitempty"`
+	E         string   `json:"e,omitempty"`
+	Curve     string   `json:"crv,omitempty"`
+	X         string   `json:"x,omitempty"`
+	Y         string   `json:"y,omitempty"`
+	X5c       []string `json:"x5c,omitempty"`
+	X5t       string   `json:"x5t,omitempty"`
+}
+
+// JSONWebKeySet is the RFC 7517 JWK Set document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewJSONWebKey builds a JWK for the public key. When a certificate chain is passed in, the chain is
+// added as x5c and the SHA-1 thumbprint of the leaf certificate as x5t.
+func NewJSONWebKey(pubKey crypto.PublicKey, keyId, alg string, certChain []*x509.Certificate) (*JSONWebKey, error) {
+	jwk := JSONWebKey{
+		Use:       "sig",
+		KeyId:     keyId,
+		Algorithm: alg,
+	}
+
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		// coordinates have to be padded to the full size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return nil, fmt.Errorf("unsupported public key type for JWK. only RSA, ECDSA and Ed25519 supported")
+	}
+
+	for _, cert := range certChain {
+		jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	if len(certChain) > 0 {
+		thumbprint := sha1.Sum(certChain[0].Raw)
+		jwk.X5t = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	}
+	return &jwk, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// PublicKey returns the public key described by the key parameters of the JWK
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus in JWK with kid %s", k.KeyId)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent in JWK with kid %s", k.KeyId)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s in JWK with kid %s", k.Curve, k.KeyId)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates in JWK with kid %s", k.KeyId)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC point is not on curve %s in JWK with kid %s", k.Curve, k.KeyId)
+		}
+		return key, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s in JWK with kid %s", k.Curve, k.KeyId)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key in JWK with kid %s", k.KeyId)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s in JWK with kid %s", k.KeyType, k.KeyId)
+	}
+}
+
+// GetCertChain decodes the x5c member of the JWK. The leaf certificate is the first one in the slice
+func (k *JSONWebKey) GetCertChain() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, certB64 := range k.X5c {
+		der, err := base64.StdEncoding.DecodeString(certB64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x5c entry in JWK with kid %s", k.KeyId)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse x5c certificate in JWK with kid %s: %v", k.KeyId, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// GetJwks returns the public key of the factory as a JWK Set. The kid of the key is the same as the one
+// that is put in the header of the tokens created by the factory.
+func (f *JwtFactory) GetJwks() (*JSONWebKeySet, error) {
+	jwk, err := NewJSONWebKey(f.signer.Public(), f.keyId, f.signingMethod.Alg(), f.signingCerts)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONWebKeySet{Keys: []JSONWebKey{*jwk}}, nil
+}
+
+// GetJwksJson returns the JWK Set of the factory as a json document that can be served to verifiers
+func (f *JwtFactory) GetJwksJson() ([]byte, error) {
+	jwks, err := f.GetJwks()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwks)
+}
+
+// ParseJwks parses a JWK Set json document
+func ParseJwks(jwksJson []byte) (*JSONWebKeySet, error) {
+	var jwks JSONWebKeySet
+	if err := json.Unmarshal(jwksJson, &jwks); err != nil {
+		return nil, fmt.Errorf("could not parse JWKS document: %v", err)
+	}
+	return &jwks, nil
+}
+
+// newVerifierFromJwks builds the key map of the verifier from the signing keys in the JWK Set. Keys that carry
+// a certificate chain are subject to the same trust checks as the certificates passed to NewVerifier.
+func newVerifierFromJwks(jwks *JSONWebKeySet, rootCAPems [][]byte, cacheTime time.Duration, opts *VerifyOptions) *verifierPrivate {
+
+	v := verifierPrivate{expiration: time.Now().Add(cacheTime), options: opts}
+	v.pubKeyMap = make(map[string]verifierKey)
+
+	roots := x509.NewCertPool()
+	for _, rootPEM := range rootCAPems {
+		roots.AppendCertsFromPEM(rootPEM)
+	}
+
+	for i := range jwks.Keys {
+		jwk := &jwks.Keys[i]
+		// tokens without kid are not accepted, so there is no point keeping keys without one
+		if jwk.KeyId == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		pubKey, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		expTime := v.expiration
+
+		if len(jwk.X5c) > 0 {
+			certs, err := jwk.GetCertChain()
+			if err != nil {
+				continue
+			}
+			cert := certs[0]
+			if time.Now().After(cert.NotAfter) {
+				continue
+			}
+			certPubKey, err := crypt.GetPublicKeyFromCert(cert)
+			if err != nil || !publicKeysEqual(pubKey, certPubKey) {
+				continue
+			}
+			if !(cert.IsCA && cert.BasicConstraintsValid) {
+				verifyRootCAOpts := x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: x509.NewCertPool(),
+				}
+				for _, intermediate := range certs[1:] {
+					verifyRootCAOpts.Intermediates.AddCert(intermediate)
+				}
+				if _, err := cert.Verify(verifyRootCAOpts); err != nil {
+					continue
+				}
+			}
+			expTime = cert.NotAfter
+			if v.expiration.After(cert.NotAfter) {
+				v.expiration = cert.NotAfter
+			}
+		}
+		v.pubKeyMap[jwk.KeyId] = verifierKey{pubKey: pubKey, expTime: expTime}
+	}
+	return &v
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	switch keyA := a.(type) {
+	case *rsa.PublicKey:
+		keyB, ok := b.(*rsa.PublicKey)
+		return ok && keyA.E == keyB.E && keyA.N.Cmp(keyB.N) == 0
+	case *ecdsa.PublicKey:
+		keyB, ok := b.(*ecdsa.PublicKey)
+		return ok && keyA.Curve == keyB.Curve && keyA.X.Cmp(keyB.X) == 0 && keyA.Y.Cmp(keyB.Y) == 0
+	case ed25519.PublicKey:
+		keyB, ok := b.(ed25519.PublicKey)
+		return ok && bytes.Equal(keyA, keyB)
+	}
+	return false
+}
+
+type jwksVerifier struct {
+	source      string
+	rootCAPems  [][]byte
+	cacheTime   time.Duration
+	options     *VerifyOptions
+	client      *http.Client
+	mtx         sync.Mutex
+	verifier    *verifierPrivate
+	lastRefresh time.Time
+}
+
+// NewVerifierFromJwks creates a verifier whose keys come from a JWKS document. jwksSource is either the path of
+// a file or an https URL. When downloading, the server has to present a certificate trusted by rootCAPems.
+// The keys are cached for cacheTime. The document is fetched again when the cache expires or a token with an
+// unknown kid shows up. An optional VerifyOptions can be passed in to restrict the tokens that are accepted.
+func NewVerifierFromJwks(jwksSource string, rootCAPems [][]byte, cacheTime time.Duration, options ...VerifyOptions) (Verifier, error) {
+	if jwksSource == "" {
+		return nil, fmt.Errorf("JWKS source cannot be empty")
+	}
+	if strings.HasPrefix(strings.ToLower(jwksSource), "http://") {
+		return nil, fmt.Errorf("JWKS can only be downloaded over https")
+	}
+	opts, err := getVerifyOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	jv := jwksVerifier{
+		source:     jwksSource,
+		rootCAPems: rootCAPems,
+		cacheTime:  cacheTime,
+		options:    opts,
+	}
+
+	if isJwksUrl(jwksSource) {
+		rootCAs := x509.NewCertPool()
+		for _, rootPEM := range rootCAPems {
+			rootCAs.AppendCertsFromPEM(rootPEM)
+		}
+		jv.client = &http.Client{
+			Timeout: jwksHttpTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: false,
+					RootCAs:            rootCAs,
+				},
+			},
+		}
+	}
+
+	if err := jv.load(); err != nil {
+		return nil, err
+	}
+	return &jv, nil
+}
+
+func isJwksUrl(source string) bool {
+	return strings.HasPrefix(strings.ToLower(source), "https://")
+}
+
+func (jv *jwksVerifier) fetch() ([]byte, error) {
+	if jv.client == nil {
+		return ioutil.ReadFile(jv.source)
+	}
+
+	req, err := http.NewRequest("GET", jv.source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create JWKS request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := jv.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not download JWKS from %s: %v", jv.source, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download JWKS from %s. HTTP Status Code: %d", jv.source, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, jwksMaxDocumentSize))
+}
+
+// load retrieves the JWKS document and replaces the cached keys. Caller should hold the mutex unless
+// it is called from the constructor
+func (jv *jwksVerifier) load() error {
+	jwksJson, err := jv.fetch()
+	jv.lastRefresh = time.Now()
+	if err != nil {
+		return err
+	}
+	jwks, err := ParseJwks(jwksJson)
+	if err != nil {
+		return err
+	}
+	jv.verifier = newVerifierFromJwks(jwks, jv.rootCAPems, jv.cacheTime, jv.options)
+	return nil
+}
+
+func (jv *jwksVerifier) current() *verifierPrivate {
+	jv.mtx.Lock()
+	defer jv.mtx.Unlock()
+	return jv.verifier
+}
+
+// refresh downloads the JWKS again unless some other request has already done so since the stale verifier was
+// handed out. Refreshes are throttled even if the cached keys have expired, since otherwise a verifier with a short
+// cacheTime would download the JWKS for every token with an unknown kid.
+func (jv *jwksVerifier) refresh(stale *verifierPrivate) (*verifierPrivate, error) {
+	jv.mtx.Lock()
+	defer jv.mtx.Unlock()
+
+	if jv.verifier != stale {
+		return jv.verifier, nil
+	}
+	if time.Since(jv.lastRefresh) < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("JWKS was refreshed less than %v ago", jwksMinRefreshInterval)
+	}
+	if err := jv.load(); err != nil {
+		return nil, err
+	}
+	return jv.verifier, nil
+}
+
+func (jv *jwksVerifier) ValidateTokenAndGetClaims(tokenString string, customClaims interface{}) (*Token, error) {
+	v := jv.current()
+	token, err := v.ValidateTokenAndGetClaims(tokenString, customClaims)
+	switch err.(type) {
+	case *MatchingCertNotFoundError, *MatchingCertJustExpired, *VerifierExpiredError:
+		refreshed, refreshErr := jv.refresh(v)
+		if refreshErr != nil {
+			return nil, err
+		}
+		return refreshed.ValidateTokenAndGetClaims(tokenString, customClaims)
+	}
+	return token, err
+}
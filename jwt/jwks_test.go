@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/pem"
+	"intel/isecl/lib/common/v2/crypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClaims struct {
+	Name string `json:"name"`
+}
+
+func createTestFactory(t *testing.T) *JwtFactory {
+	cert, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("jwt signing", "", "ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	factory, err := NewTokenFactory(pkcs8Der, true, certPem, "AAS JWT Issuer", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return factory
+}
+
+func TestJwksRoundTrip(t *testing.T) {
+	factory := createTestFactory(t)
+
+	jwks, err := factory.GetJwks()
+	assert.NoError(t, err)
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, factory.keyId, jwks.Keys[0].KeyId)
+	assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+	assert.Equal(t, "ES384", jwks.Keys[0].Algorithm)
+	assert.Len(t, jwks.Keys[0].X5c, 1)
+	assert.NotEmpty(t, jwks.Keys[0].X5t)
+
+	jwksJson, err := factory.GetJwksJson()
+	assert.NoError(t, err)
+	parsed, err := ParseJwks(jwksJson)
+	assert.NoError(t, err)
+	pubKey, err := parsed.Keys[0].PublicKey()
+	assert.NoError(t, err)
+	assert.True(t, publicKeysEqual(pubKey, factory.signingCerts[0].PublicKey))
+}
+
+func TestVerifierFromJwksFile(t *testing.T) {
+	factory := createTestFactory(t)
+	jwksJson, err := factory.GetJwksJson()
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	assert.NoError(t, ioutil.WriteFile(jwksPath, jwksJson, 0600))
+
+	verifier, err := NewVerifierFromJwks(jwksPath, nil, time.Hour)
+	assert.NoError(t, err)
+
+	tokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	claims := testClaims{}
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Name)
+
+	otherFactory := createTestFactory(t)
+	tokenString, err = otherFactory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+	assert.IsType(t, &MatchingCertNotFoundError{}, err)
+}
+
+func TestVerifierFromJwksUrlRefreshesOnUnknownKid(t *testing.T) {
+	oldFactory := createTestFactory(t)
+	newFactory := createTestFactory(t)
+
+	var mtx sync.Mutex
+	current := oldFactory
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		jwksJson, _ := current.GetJwksJson()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwksJson)
+	}))
+	defer server.Close()
+	serverCaPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	verifier, err := NewVerifierFromJwks(server.URL+"/jwks", [][]byte{serverCaPem}, time.Hour)
+	assert.NoError(t, err)
+
+	// the issuer switches over to a new key. The verifier should pick it up on the first token with the new kid
+	mtx.Lock()
+	current = newFactory
+	mtx.Unlock()
+	verifier.(*jwksVerifier).lastRefresh = time.Now().Add(-1 * jwksMinRefreshInterval)
+
+	tokenString, err := newFactory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+	claims := testClaims{}
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Name)
+
+	// refresh is throttled, so an unknown kid right after a refresh does not cause another download
+	tokenString, err = createTestFactory(t).Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+	assert.IsType(t, &MatchingCertNotFoundError{}, err)
+}
+
+func TestVerifierFromJwksUrlThrottlesExpiredCache(t *testing.T) {
+	factory := createTestFactory(t)
+	var mtx sync.Mutex
+	downloads := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		downloads++
+		mtx.Unlock()
+		jwksJson, _ := factory.GetJwksJson()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwksJson)
+	}))
+	defer server.Close()
+	serverCaPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// with no cache time the keys expire right away, tokens with unknown kids must not trigger downloads anyway
+	verifier, err := NewVerifierFromJwks(server.URL+"/jwks", [][]byte{serverCaPem}, 0)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		tokenString, err := createTestFactory(t).Create(&testClaims{Name: "admin"}, "admin", 0)
+		assert.NoError(t, err)
+		_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+		assert.Error(t, err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, 1, downloads)
+}
+
+func TestVerifierFromJwksRejectsPlainHttp(t *testing.T) {
+	_, err := NewVerifierFromJwks("http://localhost/jwks", nil, time.Hour)
+	assert.Error(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
@@ -59,11 +60,12 @@ func (e NoValidCertFoundError) Error() string {
 }
 
 type JwtFactory struct {
-	privKey       crypto.PrivateKey
+	signer        crypto.Signer
 	issuer        string
 	tokenValidity time.Duration
 	signingMethod jwt.SigningMethod
 	keyId         string
+	signingCerts  []*x509.Certificate
 }
 
 type StandardClaims jwt.StandardClaims
@@ -105,6 +107,60 @@ func (t *Token) GetHeader() *map[string]interface{} {
 	return &t.jwtToken.Header
 }
 
+// GetSubject returns the sub claim of the token
+func (t *Token) GetSubject() string {
+	if t.standardClaims == nil {
+		return ""
+	}
+	return t.standardClaims.Subject
+}
+
+// GetIssuer returns the iss claim of the token
+func (t *Token) GetIssuer() string {
+	if t.standardClaims == nil {
+		return ""
+	}
+	return t.standardClaims.Issuer
+}
+
+// GetExpiresAt returns the exp claim of the token. The zero time is returned if the token does not expire
+func (t *Token) GetExpiresAt() time.Time {
+	if t.standardClaims == nil || t.standardClaims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t.standardClaims.ExpiresAt, 0)
+}
+
+// ParseTokenUnverified parses a token without verifying the signature or any of the claims. customClaims can be
+// nil if only the standard claims are needed. The result must not be used for any security decision unless the
+// token has been validated by a Verifier as well.
+func ParseTokenUnverified(tokenString string, customClaims interface{}) (*Token, error) {
+	token := Token{}
+	parsedClaims := tokenClaims{}
+	parser := jwt.Parser{}
+	parsedToken, parts, err := parser.ParseUnverified(tokenString, &parsedClaims)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedClaims.Audience) == 1 {
+		parsedClaims.StandardClaims.Audience = parsedClaims.Audience[0]
+	}
+	token.jwtToken = parsedToken
+	token.standardClaims = &parsedClaims.StandardClaims
+
+	if customClaims != nil {
+		claimBytes, err := jwt.DecodeSegment(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("could not decode claims part of the jwt token")
+		}
+		if err = json.Unmarshal(claimBytes, customClaims); err != nil {
+			return nil, fmt.Errorf("could not parse claims of the jwt token: %v", err)
+		}
+		token.customClaims = customClaims
+	}
+	return &token, nil
+}
+
 type verifierKey struct{
 	pubKey crypto.PublicKey
 	expTime time.Time
@@ -113,32 +169,37 @@ type verifierKey struct{
 type verifierPrivate struct {
 	expiration time.Time
 	pubKeyMap  map[string] verifierKey
+	options    *VerifyOptions
 }
 
 type Verifier interface {
 	ValidateTokenAndGetClaims(tokenString string, customClaims interface{}) (*Token, error)
 }
 
-func getJwtSigningMethod(privKey crypto.PrivateKey) (jwt.SigningMethod, error) {
+// getJwtSigningMethod returns the signing method for the public key of the token signing key. The returned method
+// signs with a crypto.Signer
+func getJwtSigningMethod(pubKey crypto.PublicKey) (jwt.SigningMethod, error) {
 
-	switch key := privKey.(type) {
-	case *rsa.PrivateKey:
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey:
 		bitLen := key.N.BitLen()
 		if bitLen != 3072 && bitLen != 4096 {
 			return nil, fmt.Errorf("RSA keylength for JWT signing must be 3072 or 4096")
 		}
-		return jwt.GetSigningMethod("RS384"), nil
-	case *ecdsa.PrivateKey:
+		return &signerSigningMethod{SigningMethod: jwt.SigningMethodRS384, hash: crypto.SHA384}, nil
+	case *ecdsa.PublicKey:
 		bitLen := key.Curve.Params().BitSize
 		if bitLen != 256 && bitLen != 384 {
-			return nil, fmt.Errorf("RSA keylength for JWT signing must be 256 or 384")
+			return nil, fmt.Errorf("ECDSA keylength for JWT signing must be 256 or 384")
 		}
 		if bitLen == 384 {
-			return jwt.GetSigningMethod("ES384"), nil
+			return &signerSigningMethod{SigningMethod: jwt.SigningMethodES384, hash: crypto.SHA384, ecdsaKeySize: 48}, nil
 		}
-		return jwt.GetSigningMethod("ES256"), nil
+		return &signerSigningMethod{SigningMethod: jwt.SigningMethodES256, hash: crypto.SHA256, ecdsaKeySize: 32}, nil
+	case ed25519.PublicKey:
+		return &signerSigningMethod{SigningMethod: SigningMethodEd25519}, nil
 	default:
-		return nil, fmt.Errorf("unsupported key type for JWT signing. only RSA and ECDSA supported")
+		return nil, fmt.Errorf("unsupported key type for JWT signing. only RSA, ECDSA and Ed25519 supported")
 	}
 
 }
@@ -147,20 +208,31 @@ func getJwtSigningMethod(privKey crypto.PrivateKey) (jwt.SigningMethod, error) {
 // basically, it allows to load the private key just once and keep using it. The issuer and default
 // validity can be passed in so that these do not have to be passed in every time.
 func NewTokenFactory(pkcs8der []byte, includeKeyIdInToken bool, signingCertPem []byte, issuer string, tokenValidity time.Duration) (*JwtFactory, error) {
-	if tokenValidity == 0 {
-		tokenValidity = defaultTokenValidity
-	}
-
 	key, err := x509.ParsePKCS8PrivateKey(pkcs8der)
 	if err != nil {
 		return nil, err
 	}
-	signingMethod, err := getJwtSigningMethod(key)
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type for JWT signing. only RSA, ECDSA and Ed25519 supported")
+	}
+	return NewTokenFactoryWithSigner(signer, includeKeyIdInToken, signingCertPem, issuer, tokenValidity)
+}
+
+// NewTokenFactoryWithSigner creates a factory like NewTokenFactory that signs the tokens with signer, for instance
+// a key from a keystore.KeyStore, so that the private key does not have to be in memory
+func NewTokenFactoryWithSigner(signer crypto.Signer, includeKeyIdInToken bool, signingCertPem []byte, issuer string, tokenValidity time.Duration) (*JwtFactory, error) {
+	if tokenValidity == 0 {
+		tokenValidity = defaultTokenValidity
+	}
+
+	signingMethod, err := getJwtSigningMethod(signer.Public())
 	if err != nil {
 		return nil, err
 	}
 
 	var keyId string
+	var signingCerts []*x509.Certificate
 
 	//todo - we need to decide if we should use the information in the cert
 	if includeKeyIdInToken && len(signingCertPem) > 0 {
@@ -175,13 +247,18 @@ func NewTokenFactory(pkcs8der []byte, includeKeyIdInToken bool, signingCertPem [
 		hash, _ := crypt.GetHashData(cert.Raw, crypto.SHA1)
 		keyId = hex.EncodeToString(hash)
 
+		// keep the whole chain around so that it can be published as part of the JWKS (x5c)
+		if signingCerts, err = crypt.GetCertChainFromPem(signingCertPem); err != nil {
+			return nil, fmt.Errorf("NewTokenFactory: failed to parse certificate chain: %v", err)
+		}
 	}
 
-	return &JwtFactory{privKey: key,
+	return &JwtFactory{signer: signer,
 		issuer:        issuer,
 		tokenValidity: tokenValidity,
 		signingMethod: signingMethod,
 		keyId:         keyId,
+		signingCerts:  signingCerts,
 	}, nil
 }
 
@@ -218,13 +295,19 @@ func (f *JwtFactory) Create(clms interface{}, subject string, validity time.Dura
 	jwtclaim.StandardClaims.ExpiresAt = now.Add(validity).Unix()
 	jwtclaim.StandardClaims.Issuer = f.issuer
 	jwtclaim.StandardClaims.Subject = subject
+	// unique token id so that the token can be revoked before it expires
+	tokenId, err := crypt.GetHexRandomString(16)
+	if err != nil {
+		return "", fmt.Errorf("could not generate jti (token id): %v", err)
+	}
+	jwtclaim.StandardClaims.Id = tokenId
 
 	jwtclaim.customClaims = clms
 	token := jwt.NewWithClaims(f.signingMethod, jwtclaim)
 	if f.keyId != "" {
 		token.Header["kid"] = f.keyId
 	}
-	return token.SignedString(f.privKey)
+	return token.SignedString(f.signer)
 
 }
 
@@ -236,8 +319,16 @@ func (v *verifierPrivate) ValidateTokenAndGetClaims(tokenString string, customCl
 	// let us check if the verifier is already expired. If it is just return verifier expired error
 	// The caller has to re-initialize the verifier.
 	token := Token{}
-	token.standardClaims = &jwt.StandardClaims{}
-	parsedToken, err := jwt.ParseWithClaims(tokenString, token.standardClaims, func(token *jwt.Token) (interface{}, error) {
+	verifiedClaims := tokenClaims{}
+	token.standardClaims = &verifiedClaims.StandardClaims
+	// registered claims are checked by validateClaims after the signature is verified so that the leeway
+	// and the rest of the verify options can be applied
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	parsedToken, err := parser.ParseWithClaims(tokenString, &verifiedClaims, func(token *jwt.Token) (interface{}, error) {
+
+		if !v.options.algorithmAllowed(token.Method.Alg()) {
+			return nil, &AlgorithmNotAllowedError{token.Method.Alg()}
+		}
 
 		if keyIDValue, keyIDExists := token.Header["kid"]; keyIDExists {
 
@@ -270,7 +361,7 @@ func (v *verifierPrivate) ValidateTokenAndGetClaims(tokenString string, customCl
 	if err != nil {
 		if jwtErr, ok := err.(*jwt.ValidationError); ok {
 			switch e := jwtErr.Inner.(type){
-			case *MatchingCertNotFoundError, *VerifierExpiredError, *MatchingCertJustExpired:
+			case *MatchingCertNotFoundError, *VerifierExpiredError, *MatchingCertJustExpired, *AlgorithmNotAllowedError:
 				return nil, e
 			}
 			return nil, jwtErr
@@ -293,6 +384,17 @@ func (v *verifierPrivate) ValidateTokenAndGetClaims(tokenString string, customCl
 	if claimBytes, err = jwt.DecodeSegment(parts[1]); err != nil {
 		return nil, fmt.Errorf("could not decode claims part of the jwt token")
 	}
+	allClaims := make(map[string]interface{})
+	if err = json.Unmarshal(claimBytes, &allClaims); err != nil {
+		return nil, fmt.Errorf("could not decode claims part of the jwt token")
+	}
+	if err = v.options.validateClaims(&verifiedClaims, allClaims); err != nil {
+		return nil, err
+	}
+	if len(verifiedClaims.Audience) == 1 {
+		verifiedClaims.StandardClaims.Audience = verifiedClaims.Audience[0]
+	}
+
 	dec := json.NewDecoder(bytes.NewBuffer(claimBytes))
 	err = dec.Decode(customClaims)
 	token.customClaims = customClaims
@@ -300,10 +402,16 @@ func (v *verifierPrivate) ValidateTokenAndGetClaims(tokenString string, customCl
 	return &token, nil
 }
 
-func NewVerifier(signingCertPems interface{}, rootCAPems [][]byte, cacheTime time.Duration) (Verifier, error) {
+// NewVerifier creates a verifier that trusts the signing certificates passed in. An optional VerifyOptions
+// can be passed in to restrict the tokens that are accepted.
+func NewVerifier(signingCertPems interface{}, rootCAPems [][]byte, cacheTime time.Duration, options ...VerifyOptions) (Verifier, error) {
 
+	opts, err := getVerifyOptions(options)
+	if err != nil {
+		return nil, err
+	}
 
-	v := verifierPrivate{expiration: time.Now().Add(cacheTime)}
+	v := verifierPrivate{expiration: time.Now().Add(cacheTime), options: opts}
 	v.pubKeyMap = make(map[string]verifierKey)
 
 	var certPemSlice [][]byte
@@ -0,0 +1,268 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RevocationStore keeps the jti (token id) of tokens that have been revoked before their expiry. Entries only
+// need to be kept until the token expires, after which the verifier rejects the token anyway.
+type RevocationStore interface {
+	// Revoke adds the token id to the denylist until expiresAt
+	Revoke(tokenId string, expiresAt time.Time) error
+	// IsRevoked reports whether the token id is on the denylist
+	IsRevoked(tokenId string) (bool, error)
+	// Prune removes the entries of tokens that have expired
+	Prune() error
+}
+
+type TokenRevokedError struct {
+	TokenId string
+}
+
+func (e TokenRevokedError) Error() string {
+	return fmt.Sprintf("token has been revoked. jti (token id) : %s", e.TokenId)
+}
+
+// RevocationCheckError is returned when the revocation store could not be consulted. The token is rejected then,
+// since it might have been revoked
+type RevocationCheckError struct {
+	TokenId string
+	Err     error
+}
+
+func (e RevocationCheckError) Error() string {
+	return fmt.Sprintf("could not check if token is revoked. jti (token id) : %s, error : %v", e.TokenId, e.Err)
+}
+
+// RevokeToken adds a token that has been validated by a Verifier to the revocation store
+func RevokeToken(store RevocationStore, token *Token) error {
+	if token == nil || token.standardClaims == nil {
+		return fmt.Errorf("token has not been parsed")
+	}
+	if token.standardClaims.Id == "" {
+		return fmt.Errorf("token cannot be revoked since it does not have a jti (token id)")
+	}
+	expiresAt := time.Unix(token.standardClaims.ExpiresAt, 0)
+	// tokens without exp are valid forever, so they have to stay on the denylist forever
+	if token.standardClaims.ExpiresAt == 0 {
+		expiresAt = time.Unix(1<<62, 0)
+	}
+	return store.Revoke(token.standardClaims.Id, expiresAt)
+}
+
+// GetTokenId returns the jti (token id) of the token
+func (t *Token) GetTokenId() string {
+	if t.standardClaims == nil {
+		return ""
+	}
+	return t.standardClaims.Id
+}
+
+// MemoryRevocationStore is a RevocationStore that only lives as long as the process
+type MemoryRevocationStore struct {
+	mtx     sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+func (s *MemoryRevocationStore) Revoke(tokenId string, expiresAt time.Time) error {
+	if tokenId == "" {
+		return fmt.Errorf("token id cannot be empty")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.revoked[tokenId] = expiresAt
+	pruneRevoked(s.revoked, time.Now())
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(tokenId string) (bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	_, revoked := s.revoked[tokenId]
+	return revoked, nil
+}
+
+func (s *MemoryRevocationStore) Prune() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	pruneRevoked(s.revoked, time.Now())
+	return nil
+}
+
+// pruneRevoked drops entries whose token has expired. A token is only dropped once the clock skew grace period
+// has passed as well, since a verifier could still be accepting it.
+func pruneRevoked(revoked map[string]time.Time, now time.Time) {
+	for tokenId, expiresAt := range revoked {
+		if now.After(expiresAt.Add(gracePeriodForClockSkew)) {
+			delete(revoked, tokenId)
+		}
+	}
+}
+
+// FileRevocationStore is a RevocationStore persisted as a json file, so that revocations survive a restart and
+// can be shared by the processes on a host. Changes made to the file by other processes are picked up. Updates
+// hold an exclusive flock on a lock file next to it (path + ".lock"), so that concurrent updates by several
+// processes do not drop each other's revocations.
+type FileRevocationStore struct {
+	mtx  sync.Mutex
+	path string
+	// stat of the file when it was last read or written. Since the file is always replaced by a rename,
+	// a change of the underlying file is detected even when the modification time has not moved
+	fileInfo os.FileInfo
+	revoked  map[string]time.Time
+}
+
+// NewFileRevocationStore creates a store backed by the file at path. The file is created if it does not exist
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	s := FileRevocationStore{path: path, revoked: make(map[string]time.Time)}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err = s.save(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// lock takes the exclusive lock that serializes updates of the file across processes. The returned function
+// releases it
+func (s *FileRevocationStore) lock() (func(), error) {
+	lockFile, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open token revocation lock file: %v", err)
+	}
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("could not lock token revocation file: %v", err)
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+// reload reads the file again if it was modified since it was last read. Caller should hold the mutex
+func (s *FileRevocationStore) reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read token revocation file: %v", err)
+	}
+	if s.fileInfo != nil && os.SameFile(fi, s.fileInfo) && fi.ModTime().Equal(s.fileInfo.ModTime()) {
+		return nil
+	}
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read token revocation file: %v", err)
+	}
+	expiries := make(map[string]int64)
+	if err = json.Unmarshal(content, &expiries); err != nil {
+		return fmt.Errorf("could not parse token revocation file: %v", err)
+	}
+	revoked := make(map[string]time.Time, len(expiries))
+	for tokenId, expiresAt := range expiries {
+		revoked[tokenId] = time.Unix(expiresAt, 0)
+	}
+	s.revoked = revoked
+	s.fileInfo = fi
+	return nil
+}
+
+// save writes the denylist to a temporary file and renames it so that readers never see a partial file. The
+// expiry is stored in unix seconds, since the expiry of tokens without exp is beyond what time.Time marshals to
+// json. Caller should hold the mutex
+func (s *FileRevocationStore) save() error {
+	expiries := make(map[string]int64, len(s.revoked))
+	for tokenId, expiresAt := range s.revoked {
+		expiries[tokenId] = expiresAt.Unix()
+	}
+	content, err := json.Marshal(expiries)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not write token revocation file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("could not write token revocation file: %v", err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("could not write token revocation file: %v", err)
+	}
+	os.Chmod(tmpFile.Name(), 0640)
+	if err = os.Rename(tmpFile.Name(), s.path); err != nil {
+		return fmt.Errorf("could not write token revocation file: %v", err)
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.fileInfo = fi
+	}
+	return nil
+}
+
+func (s *FileRevocationStore) Revoke(tokenId string, expiresAt time.Time) error {
+	if tokenId == "" {
+		return fmt.Errorf("token id cannot be empty")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = s.reload(); err != nil {
+		return err
+	}
+	s.revoked[tokenId] = expiresAt
+	pruneRevoked(s.revoked, time.Now())
+	return s.save()
+}
+
+func (s *FileRevocationStore) IsRevoked(tokenId string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	_, revoked := s.revoked[tokenId]
+	return revoked, nil
+}
+
+func (s *FileRevocationStore) Prune() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = s.reload(); err != nil {
+		return err
+	}
+	pruneRevoked(s.revoked, time.Now())
+	return s.save()
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRevocation(t *testing.T, store RevocationStore) {
+	factory := createTestFactory(t)
+	verifier, err := NewVerifier(getSigningCertPems(factory), nil, time.Hour, VerifyOptions{RevocationStore: store})
+	assert.NoError(t, err)
+
+	tokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+	otherTokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	token, err := verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.GetTokenId())
+
+	assert.NoError(t, RevokeToken(store, token))
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.IsType(t, &TokenRevokedError{}, err)
+
+	// other tokens of the same user are not affected
+	_, err = verifier.ValidateTokenAndGetClaims(otherTokenString, &testClaims{})
+	assert.NoError(t, err)
+}
+
+func testPrune(t *testing.T, store RevocationStore) {
+	assert.NoError(t, store.Revoke("expired", time.Now().Add(-1*time.Hour)))
+	assert.NoError(t, store.Revoke("valid", time.Now().Add(time.Hour)))
+	assert.NoError(t, store.Prune())
+
+	revoked, err := store.IsRevoked("expired")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = store.IsRevoked("valid")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	testRevocation(t, NewMemoryRevocationStore())
+	testPrune(t, NewMemoryRevocationStore())
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.json")
+
+	store, err := NewFileRevocationStore(path)
+	assert.NoError(t, err)
+	testRevocation(t, store)
+	testPrune(t, store)
+
+	// revocations are visible to other stores using the same file
+	otherStore, err := NewFileRevocationStore(path)
+	assert.NoError(t, err)
+	revoked, err := otherStore.IsRevoked("valid")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	assert.NoError(t, otherStore.Revoke("another", time.Now().Add(time.Hour)))
+	revoked, err = store.IsRevoked("another")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestFileRevocationStoreTokenWithoutExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.json")
+	store, err := NewFileRevocationStore(path)
+	assert.NoError(t, err)
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"jti": "no-exp", "sub": "admin"}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	token, err := ParseTokenUnverified(tokenString, nil)
+	assert.NoError(t, err)
+
+	// tokens without exp stay revoked forever, which has to survive being persisted
+	assert.NoError(t, RevokeToken(store, token))
+	assert.NoError(t, store.Prune())
+	otherStore, err := NewFileRevocationStore(path)
+	assert.NoError(t, err)
+	revoked, err := otherStore.IsRevoked("no-exp")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestFileRevocationStoreConcurrentStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.json")
+
+	// stores on the same file do not share the mutex, like stores in different processes
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		store, err := NewFileRevocationStore(path)
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, store.Revoke(fmt.Sprintf("token-%d-%d", i, j), time.Now().Add(time.Hour)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := NewFileRevocationStore(path)
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			revoked, err := store.IsRevoked(fmt.Sprintf("token-%d-%d", i, j))
+			assert.NoError(t, err)
+			assert.True(t, revoked, "token-%d-%d", i, j)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyState describes where a signing key is in its rotation lifecycle
+type KeyState int
+
+const (
+	// KeyNext is a key that has been published to verifiers but is not used for signing yet
+	KeyNext KeyState = iota
+	// KeyActive is the key used to sign new tokens. There is exactly one active key at any time
+	KeyActive
+	// KeyRetiring is a previously active key. It is still published until every token it signed has expired
+	KeyRetiring
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyNext:
+		return "next"
+	case KeyActive:
+		return "active"
+	case KeyRetiring:
+		return "retiring"
+	}
+	return "unknown"
+}
+
+type rotatingKey struct {
+	factory    *JwtFactory
+	state      KeyState
+	activateAt time.Time
+	// latest expiry of a token signed with this key. Once this time passes a retiring key can be dropped
+	lastTokenExpiry time.Time
+}
+
+// RotatingJwtFactory signs tokens like JwtFactory but holds an ordered set of keys so that the signing key can be
+// replaced without invalidating tokens that are still outstanding. New keys are added as "next" keys, which
+// are published right away so that verifiers can learn about them before any token is signed with them.
+type RotatingJwtFactory struct {
+	mtx           sync.Mutex
+	issuer        string
+	tokenValidity time.Duration
+	keys          []*rotatingKey
+}
+
+// KeyInfo describes one of the keys held by a RotatingJwtFactory
+type KeyInfo struct {
+	KeyId      string
+	State      KeyState
+	ActivateAt time.Time
+}
+
+func newRotatingKey(pkcs8der []byte, signingCertPem []byte, issuer string, tokenValidity time.Duration) (*rotatingKey, error) {
+	// tokens have to carry a kid, otherwise verifiers cannot tell which of the published keys to use
+	if len(signingCertPem) == 0 {
+		return nil, fmt.Errorf("signing certificate is required for keys of a rotating token factory")
+	}
+	factory, err := NewTokenFactory(pkcs8der, true, signingCertPem, issuer, tokenValidity)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingKey{factory: factory}, nil
+}
+
+// NewRotatingTokenFactory creates a rotating factory with the key that is used to sign tokens right away
+func NewRotatingTokenFactory(pkcs8der []byte, signingCertPem []byte, issuer string, tokenValidity time.Duration) (*RotatingJwtFactory, error) {
+	if tokenValidity == 0 {
+		tokenValidity = defaultTokenValidity
+	}
+	key, err := newRotatingKey(pkcs8der, signingCertPem, issuer, tokenValidity)
+	if err != nil {
+		return nil, err
+	}
+	key.state = KeyActive
+	key.activateAt = time.Now()
+	return &RotatingJwtFactory{
+		issuer:        issuer,
+		tokenValidity: tokenValidity,
+		keys:          []*rotatingKey{key},
+	}, nil
+}
+
+// AddKey adds a key in the next state. If activateAt is not zero, the key becomes the active key once that time
+// is reached. Otherwise it stays in the next state until Rotate is called.
+func (f *RotatingJwtFactory) AddKey(pkcs8der []byte, signingCertPem []byte, activateAt time.Time) (string, error) {
+	key, err := newRotatingKey(pkcs8der, signingCertPem, f.issuer, f.tokenValidity)
+	if err != nil {
+		return "", err
+	}
+	key.state = KeyNext
+	key.activateAt = activateAt
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, k := range f.keys {
+		if k.factory.keyId == key.factory.keyId {
+			return "", fmt.Errorf("key with kid %s already exists in the token factory", key.factory.keyId)
+		}
+	}
+	f.keys = append(f.keys, key)
+	return key.factory.keyId, nil
+}
+
+// Rotate makes the oldest next key the active key immediately. The previously active key is retired.
+func (f *RotatingJwtFactory) Rotate() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, k := range f.keys {
+		if k.state == KeyNext {
+			f.activate(k, time.Now())
+			return nil
+		}
+	}
+	return fmt.Errorf("there is no next key to rotate to")
+}
+
+// activate should be called with the mutex held
+func (f *RotatingJwtFactory) activate(key *rotatingKey, now time.Time) {
+	for _, k := range f.keys {
+		if k.state == KeyActive {
+			k.state = KeyRetiring
+		}
+	}
+	key.state = KeyActive
+	key.activateAt = now
+}
+
+// update performs scheduled cut-overs and drops retiring keys that no longer have outstanding tokens. It should
+// be called with the mutex held
+func (f *RotatingJwtFactory) update(now time.Time) {
+	var scheduled *rotatingKey
+	for _, k := range f.keys {
+		if k.state == KeyNext && !k.activateAt.IsZero() && !now.Before(k.activateAt) {
+			// when several keys are due, the one scheduled last wins
+			if scheduled == nil || !k.activateAt.Before(scheduled.activateAt) {
+				scheduled = k
+			}
+		}
+	}
+	if scheduled != nil {
+		f.activate(scheduled, now)
+		// keys that were scheduled before the one we just activated were never used for signing
+		keys := f.keys[:0]
+		for _, k := range f.keys {
+			if k.state == KeyNext && !k.activateAt.IsZero() && k.activateAt.Before(scheduled.activateAt) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		f.keys = keys
+	}
+
+	keys := f.keys[:0]
+	for _, k := range f.keys {
+		if k.state == KeyRetiring && now.After(k.lastTokenExpiry.Add(gracePeriodForClockSkew)) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	f.keys = keys
+}
+
+// Create generates a token signed by the active key. See JwtFactory.Create
+func (f *RotatingJwtFactory) Create(clms interface{}, subject string, validity time.Duration) (string, error) {
+	if validity == 0 {
+		validity = f.tokenValidity
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	now := time.Now()
+	f.update(now)
+	for _, k := range f.keys {
+		if k.state != KeyActive {
+			continue
+		}
+		token, err := k.factory.Create(clms, subject, validity)
+		if err != nil {
+			return "", err
+		}
+		if expiry := now.Add(validity); expiry.After(k.lastTokenExpiry) {
+			k.lastTokenExpiry = expiry
+		}
+		return token, nil
+	}
+	return "", fmt.Errorf("there is no active key in the token factory")
+}
+
+// ActiveKeyId returns the kid of the key that is currently used for signing tokens
+func (f *RotatingJwtFactory) ActiveKeyId() string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.update(time.Now())
+	for _, k := range f.keys {
+		if k.state == KeyActive {
+			return k.factory.keyId
+		}
+	}
+	return ""
+}
+
+// GetKeys returns the kid and state of all the keys held by the factory in order
+func (f *RotatingJwtFactory) GetKeys() []KeyInfo {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.update(time.Now())
+	keyInfos := make([]KeyInfo, 0, len(f.keys))
+	for _, k := range f.keys {
+		keyInfos = append(keyInfos, KeyInfo{KeyId: k.factory.keyId, State: k.state, ActivateAt: k.activateAt})
+	}
+	return keyInfos
+}
+
+// GetSigningCertsPem returns the pem encoded signing certificates (including any chain) of all keys that have
+// not been retired. The result can be passed directly to NewVerifier
+func (f *RotatingJwtFactory) GetSigningCertsPem() [][]byte {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.update(time.Now())
+	certPems := make([][]byte, 0, len(f.keys))
+	for _, k := range f.keys {
+		var certPem []byte
+		for _, cert := range k.factory.signingCerts {
+			certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+		certPems = append(certPems, certPem)
+	}
+	return certPems
+}
+
+// GetJwks returns the public keys of all keys that have not been retired as a JWK Set
+func (f *RotatingJwtFactory) GetJwks() (*JSONWebKeySet, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.update(time.Now())
+	jwks := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(f.keys))}
+	for _, k := range f.keys {
+		keyJwks, err := k.factory.GetJwks()
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, keyJwks.Keys...)
+	}
+	return &jwks, nil
+}
+
+// GetJwksJson returns the JWK Set of all keys that have not been retired as a json document
+func (f *RotatingJwtFactory) GetJwksJson() ([]byte, error) {
+	jwks, err := f.GetJwks()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwks)
+}
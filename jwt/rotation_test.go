@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"intel/isecl/lib/common/v2/crypt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateSigningKey(t *testing.T) ([]byte, []byte) {
+	privKey, pubKey, err := crypt.GenerateKeyPair("ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "JWT Signing"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, &template, pubKey, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkcs8Der, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+}
+
+func TestRotatingFactoryRotate(t *testing.T) {
+	key1, cert1 := generateSigningKey(t)
+	key2, cert2 := generateSigningKey(t)
+
+	factory, err := NewRotatingTokenFactory(key1, cert1, "AAS JWT Issuer", time.Hour)
+	assert.NoError(t, err)
+	firstKid := factory.ActiveKeyId()
+
+	oldToken, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	secondKid, err := factory.AddKey(key2, cert2, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, firstKid, factory.ActiveKeyId(), "next key should not be used before rotation")
+	assert.Len(t, factory.GetSigningCertsPem(), 2, "next key should be published before it is used")
+
+	_, err = factory.AddKey(key2, cert2, time.Time{})
+	assert.Error(t, err, "duplicate key should be rejected")
+
+	assert.NoError(t, factory.Rotate())
+	assert.Equal(t, secondKid, factory.ActiveKeyId())
+	assert.Error(t, factory.Rotate(), "there is no next key left")
+
+	newToken, err := factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	keys := factory.GetKeys()
+	assert.Len(t, keys, 2)
+	assert.Equal(t, KeyRetiring, keys[0].State)
+	assert.Equal(t, KeyActive, keys[1].State)
+
+	// tokens signed before and after the rotation verify with the published certificates
+	verifier, err := NewVerifier(factory.GetSigningCertsPem(), nil, time.Hour)
+	assert.NoError(t, err)
+	claims := testClaims{}
+	_, err = verifier.ValidateTokenAndGetClaims(oldToken, &claims)
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(newToken, &claims)
+	assert.NoError(t, err)
+
+	jwks, err := factory.GetJwks()
+	assert.NoError(t, err)
+	assert.Len(t, jwks.Keys, 2)
+}
+
+func TestRotatingFactoryScheduledCutOver(t *testing.T) {
+	key1, cert1 := generateSigningKey(t)
+	key2, cert2 := generateSigningKey(t)
+
+	factory, err := NewRotatingTokenFactory(key1, cert1, "AAS JWT Issuer", time.Hour)
+	assert.NoError(t, err)
+	firstKid := factory.ActiveKeyId()
+
+	secondKid, err := factory.AddKey(key2, cert2, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, firstKid, factory.ActiveKeyId())
+
+	// move the cut-over time into the past
+	factory.keys[1].activateAt = time.Now().Add(-1 * time.Second)
+	assert.Equal(t, secondKid, factory.ActiveKeyId())
+}
+
+func TestRotatingFactoryDropsRetiredKeys(t *testing.T) {
+	key1, cert1 := generateSigningKey(t)
+	key2, cert2 := generateSigningKey(t)
+
+	factory, err := NewRotatingTokenFactory(key1, cert1, "AAS JWT Issuer", time.Hour)
+	assert.NoError(t, err)
+	_, err = factory.Create(&testClaims{Name: "admin"}, "admin", 0)
+	assert.NoError(t, err)
+
+	_, err = factory.AddKey(key2, cert2, time.Time{})
+	assert.NoError(t, err)
+	assert.NoError(t, factory.Rotate())
+	assert.Len(t, factory.GetKeys(), 2, "retiring key has outstanding tokens")
+
+	// pretend all tokens signed by the retiring key have expired
+	factory.keys[0].lastTokenExpiry = time.Now().Add(-1 * time.Hour)
+	keys := factory.GetKeys()
+	assert.Len(t, keys, 1)
+	assert.Equal(t, KeyActive, keys[0].State)
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// signerSigningMethod signs tokens with a crypto.Signer rather than the private key types jwt-go expects, so that
+// the signing key can be kept in a KeyStore or an HSM. Verification is done by the wrapped signing method.
+type signerSigningMethod struct {
+	jwt.SigningMethod
+	hash crypto.Hash
+	// ecdsaKeySize is the size of r and s in ES256/ES384 signatures, which are their concatenation rather than the
+	// ASN.1 encoding crypto.Signer returns
+	ecdsaKeySize int
+}
+
+// Sign signs the string. key has to be a crypto.Signer
+func (m *signerSigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	digest := []byte(signingString)
+	if m.hash != 0 {
+		h := m.hash.New()
+		h.Write(digest)
+		digest = h.Sum(nil)
+	}
+	sig, err := signer.Sign(rand.Reader, digest, m.hash)
+	if err != nil {
+		return "", fmt.Errorf("could not sign token: %v", err)
+	}
+	if m.ecdsaKeySize > 0 {
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil {
+			return "", fmt.Errorf("could not parse ecdsa signature: %v", err)
+		}
+		sig = make([]byte, 2*m.ecdsaKeySize)
+		r, s := ecdsaSig.R.Bytes(), ecdsaSig.S.Bytes()
+		copy(sig[m.ecdsaKeySize-len(r):], r)
+		copy(sig[2*m.ecdsaKeySize-len(s):], s)
+	}
+	return jwt.EncodeSegment(sig), nil
+}
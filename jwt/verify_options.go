@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// VerifyOptions pins the tokens accepted by a Verifier. The zero value only checks the signature and the
+// time based claims (exp, nbf and iat) without any leeway.
+type VerifyOptions struct {
+	// Issuers is the list of accepted iss values. Any issuer is accepted when empty
+	Issuers []string
+	// Audiences is the list of accepted aud values. The token has to be meant for at least one of them.
+	// Any audience (or no audience at all) is accepted when empty
+	Audiences []string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+	// MaxTokenLifetime rejects tokens whose exp is further than this from iat. iat may be backdated by the clock
+	// skew grace period of JwtFactory.Create and the Leeway on top of that. Not enforced when zero
+	MaxTokenLifetime time.Duration
+	// RequiredClaims lists the names of claims that have to be present in the token
+	RequiredClaims []string
+	// AllowedAlgorithms lists the acceptable values of the alg header. Any supported algorithm when empty
+	AllowedAlgorithms []string
+	// RevocationStore is consulted to reject tokens that have been revoked. Tokens without a jti cannot be
+	// revoked; add "jti" to RequiredClaims to reject them
+	RevocationStore RevocationStore
+}
+
+type InvalidIssuerError struct {
+	Issuer string
+}
+
+func (e InvalidIssuerError) Error() string {
+	return fmt.Sprintf("token issuer is not trusted. iss (issuer) : %s", e.Issuer)
+}
+
+type InvalidAudienceError struct {
+	Audience []string
+}
+
+func (e InvalidAudienceError) Error() string {
+	return fmt.Sprintf("token is not meant for this audience. aud (audience) : %v", e.Audience)
+}
+
+type TokenExpiredError struct {
+	ExpiresAt time.Time
+}
+
+func (e TokenExpiredError) Error() string {
+	return fmt.Sprintf("token expired at %v", e.ExpiresAt)
+}
+
+type TokenNotValidYetError struct {
+	NotBefore time.Time
+}
+
+func (e TokenNotValidYetError) Error() string {
+	return fmt.Sprintf("token is not valid before %v", e.NotBefore)
+}
+
+type TokenLifetimeExceededError struct {
+	Lifetime    time.Duration
+	MaxLifetime time.Duration
+}
+
+func (e TokenLifetimeExceededError) Error() string {
+	return fmt.Sprintf("token lifetime %v exceeds the maximum allowed lifetime %v", e.Lifetime, e.MaxLifetime)
+}
+
+type MissingClaimError struct {
+	Claim string
+}
+
+func (e MissingClaimError) Error() string {
+	return fmt.Sprintf("required claim missing in token. claim : %s", e.Claim)
+}
+
+type AlgorithmNotAllowedError struct {
+	Algorithm string
+}
+
+func (e AlgorithmNotAllowedError) Error() string {
+	return fmt.Sprintf("token signing algorithm is not allowed. alg (algorithm) : %s", e.Algorithm)
+}
+
+// audience handles the aud claim which can either be a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("aud (audience) claim has to be a string or an array of strings")
+	}
+	*a = audience(multiple)
+	return nil
+}
+
+// tokenClaims are the registered claims checked by the verifier. The Audience field shadows the one in
+// jwt.StandardClaims so that tokens with multiple audiences can be parsed.
+type tokenClaims struct {
+	jwt.StandardClaims
+	Audience audience `json:"aud,omitempty"`
+}
+
+// Valid is not used since the claims are checked by validateClaims once the signature is verified
+func (c *tokenClaims) Valid() error {
+	return nil
+}
+
+func getVerifyOptions(options []VerifyOptions) (*VerifyOptions, error) {
+	switch len(options) {
+	case 0:
+		return &VerifyOptions{}, nil
+	case 1:
+		return &options[0], nil
+	}
+	return nil, fmt.Errorf("only a single VerifyOptions can be passed in")
+}
+
+func (opts *VerifyOptions) algorithmAllowed(alg string) bool {
+	if len(opts.AllowedAlgorithms) == 0 {
+		return true
+	}
+	for _, allowed := range opts.AllowedAlgorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// validateClaims checks the registered claims of a token whose signature has already been verified.
+// allClaims holds every claim in the token and is used to check for the required claims.
+func (opts *VerifyOptions) validateClaims(c *tokenClaims, allClaims map[string]interface{}) error {
+	now := time.Now()
+
+	if c.ExpiresAt != 0 {
+		if expiresAt := time.Unix(c.ExpiresAt, 0); now.After(expiresAt.Add(opts.Leeway)) {
+			return &TokenExpiredError{expiresAt}
+		}
+	}
+	if c.NotBefore != 0 {
+		if notBefore := time.Unix(c.NotBefore, 0); now.Add(opts.Leeway).Before(notBefore) {
+			return &TokenNotValidYetError{notBefore}
+		}
+	}
+	if c.IssuedAt != 0 {
+		if issuedAt := time.Unix(c.IssuedAt, 0); now.Add(opts.Leeway).Before(issuedAt) {
+			return &TokenNotValidYetError{issuedAt}
+		}
+	}
+
+	if len(opts.Issuers) > 0 {
+		trusted := false
+		for _, issuer := range opts.Issuers {
+			if c.Issuer == issuer {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return &InvalidIssuerError{c.Issuer}
+		}
+	}
+
+	if len(opts.Audiences) > 0 {
+		matched := false
+		for _, aud := range c.Audience {
+			for _, expected := range opts.Audiences {
+				if aud == expected {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return &InvalidAudienceError{c.Audience}
+		}
+	}
+
+	if opts.MaxTokenLifetime > 0 {
+		if c.ExpiresAt == 0 {
+			return &MissingClaimError{"exp"}
+		}
+		if c.IssuedAt == 0 {
+			return &MissingClaimError{"iat"}
+		}
+		// JwtFactory.Create backdates iat by the grace period for clock skew
+		allowed := opts.MaxTokenLifetime + gracePeriodForClockSkew + opts.Leeway
+		if lifetime := time.Duration(c.ExpiresAt-c.IssuedAt) * time.Second; lifetime > allowed {
+			return &TokenLifetimeExceededError{Lifetime: lifetime, MaxLifetime: opts.MaxTokenLifetime}
+		}
+	}
+
+	for _, claim := range opts.RequiredClaims {
+		if value, exists := allClaims[claim]; !exists || value == nil {
+			return &MissingClaimError{claim}
+		}
+	}
+
+	if opts.RevocationStore != nil && c.Id != "" {
+		revoked, err := opts.RevocationStore.IsRevoked(c.Id)
+		if err != nil {
+			return &RevocationCheckError{TokenId: c.Id, Err: err}
+		}
+		if revoked {
+			return &TokenRevokedError{c.Id}
+		}
+	}
+	return nil
+}
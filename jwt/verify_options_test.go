@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package jwtauth
+
+import (
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type audienceClaims struct {
+	Name     string   `json:"name"`
+	Audience []string `json:"aud,omitempty"`
+}
+
+func TestVerifyOptions(t *testing.T) {
+	factory := createTestFactory(t)
+	signingCerts := getSigningCertPems(factory)
+
+	tokenString, err := factory.Create(&audienceClaims{Name: "admin", Audience: []string{"HVS", "WLS"}}, "admin", time.Hour)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		options VerifyOptions
+		errType interface{}
+	}{
+		{"no options", VerifyOptions{}, nil},
+		{"trusted issuer", VerifyOptions{Issuers: []string{"other", "AAS JWT Issuer"}}, nil},
+		{"untrusted issuer", VerifyOptions{Issuers: []string{"other"}}, &InvalidIssuerError{}},
+		{"matching audience", VerifyOptions{Audiences: []string{"WLS"}}, nil},
+		{"wrong audience", VerifyOptions{Audiences: []string{"KBS"}}, &InvalidAudienceError{}},
+		{"lifetime within limit", VerifyOptions{MaxTokenLifetime: 2 * time.Hour}, nil},
+		{"lifetime exceeded", VerifyOptions{MaxTokenLifetime: 30 * time.Minute}, &TokenLifetimeExceededError{}},
+		{"required claims present", VerifyOptions{RequiredClaims: []string{"name", "sub"}}, nil},
+		{"required claim missing", VerifyOptions{RequiredClaims: []string{"roles"}}, &MissingClaimError{}},
+		{"algorithm allowed", VerifyOptions{AllowedAlgorithms: []string{"ES384"}}, nil},
+		{"algorithm not allowed", VerifyOptions{AllowedAlgorithms: []string{"RS384"}}, &AlgorithmNotAllowedError{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier, err := NewVerifier(signingCerts, nil, time.Hour, test.options)
+			assert.NoError(t, err)
+			claims := audienceClaims{}
+			_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+			if test.errType == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, "admin", claims.Name)
+			} else {
+				assert.IsType(t, test.errType, err)
+			}
+		})
+	}
+}
+
+func TestVerifyOptionsLeeway(t *testing.T) {
+	factory := createTestFactory(t)
+	signingCerts := getSigningCertPems(factory)
+
+	// a token that expired a few seconds ago is only accepted with enough leeway
+	tokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", -5*time.Second)
+	assert.NoError(t, err)
+
+	verifier, err := NewVerifier(signingCerts, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.IsType(t, &TokenExpiredError{}, err)
+
+	verifier, err = NewVerifier(signingCerts, nil, time.Hour, VerifyOptions{Leeway: time.Minute})
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.NoError(t, err)
+}
+
+func TestVerifyOptionsMaxTokenLifetime(t *testing.T) {
+	factory := createTestFactory(t)
+	signingCerts := getSigningCertPems(factory)
+
+	// tokens issued for exactly the maximum lifetime are accepted even though iat is backdated
+	tokenString, err := factory.Create(&testClaims{Name: "admin"}, "admin", time.Hour)
+	assert.NoError(t, err)
+	verifier, err := NewVerifier(signingCerts, nil, time.Hour, VerifyOptions{MaxTokenLifetime: time.Hour})
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.NoError(t, err)
+
+	tokenString, err = factory.Create(&testClaims{Name: "admin"}, "admin", time.Hour+2*time.Minute)
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.IsType(t, &TokenLifetimeExceededError{}, err)
+	verifier, err = NewVerifier(signingCerts, nil, time.Hour, VerifyOptions{MaxTokenLifetime: time.Hour, Leeway: 5 * time.Minute})
+	assert.NoError(t, err)
+	_, err = verifier.ValidateTokenAndGetClaims(tokenString, &testClaims{})
+	assert.NoError(t, err)
+}
+
+func TestNewVerifierRejectsMultipleOptions(t *testing.T) {
+	_, err := NewVerifier(nil, nil, time.Hour, VerifyOptions{}, VerifyOptions{})
+	assert.Error(t, err)
+}
+
+func getSigningCertPems(factory *JwtFactory) [][]byte {
+	var certPems [][]byte
+	for _, cert := range factory.signingCerts {
+		certPems = append(certPems, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return certPems
+}
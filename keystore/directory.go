@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package keystore
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const keyFileExtension = ".pem"
+
+// DirectoryKeyStore stores keys as PKCS8 pem files named after the label of the key in a directory
+type DirectoryKeyStore struct {
+	dir      string
+	password string
+}
+
+// DirectoryKeyStoreOptions configures the DirectoryKeyStore
+type DirectoryKeyStoreOptions struct {
+	// Password encrypts the stored keys (see crypt.SaveEncryptedPrivateKeyAsPKCS8). Keys are stored in plain
+	// if it is empty
+	Password string
+}
+
+// NewDirectoryKeyStore returns a KeyStore storing the keys in dir, which is created if it does not exist
+func NewDirectoryKeyStore(dir string, options ...DirectoryKeyStoreOptions) (*DirectoryKeyStore, error) {
+	opts := DirectoryKeyStoreOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create key store directory %s: %v", dir, err)
+	}
+	return &DirectoryKeyStore{dir: dir, password: opts.Password}, nil
+}
+
+func (ks *DirectoryKeyStore) keyPath(label string) string {
+	return filepath.Join(ks.dir, label+keyFileExtension)
+}
+
+// GenerateKey implements KeyStore
+func (ks *DirectoryKeyStore) GenerateKey(label, keyType string, keyLength int) (Signer, error) {
+	privKey, _, err := crypt.GenerateKeyPair(keyType, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal private key to pkcs8 format: %v", err)
+	}
+	return ks.ImportKey(label, pkcs8Der)
+}
+
+// ImportKey implements KeyStore
+func (ks *DirectoryKeyStore) ImportKey(label string, pkcs8Der []byte) (Signer, error) {
+	if err := validateLabel(label); err != nil {
+		return nil, err
+	}
+	privKey, err := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	if _, err = os.Stat(ks.keyPath(label)); err == nil {
+		return nil, KeyExistsError{Label: label}
+	}
+	if ks.password != "" {
+		err = crypt.SaveEncryptedPrivateKeyAsPKCS8(pkcs8Der, ks.keyPath(label), []byte(ks.password))
+	} else {
+		err = crypt.SavePrivateKeyAsPKCS8(pkcs8Der, ks.keyPath(label))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newFileSigner(label, privKey)
+}
+
+// ListKeys implements KeyStore
+func (ks *DirectoryKeyStore) ListKeys() ([]string, error) {
+	files, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key store directory %s: %v", ks.dir, err)
+	}
+	labels := []string{}
+	for _, f := range files {
+		label := strings.TrimSuffix(f.Name(), keyFileExtension)
+		if f.Mode().IsRegular() && label != f.Name() && validateLabel(label) == nil {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// GetSigner implements KeyStore
+func (ks *DirectoryKeyStore) GetSigner(label string) (Signer, error) {
+	if err := validateLabel(label); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(ks.keyPath(label)); os.IsNotExist(err) {
+		return nil, KeyNotFoundError{Label: label}
+	}
+	privKey, err := crypt.GetPrivateKeyFromPKCS8FileWithPassword(ks.keyPath(label), []byte(ks.password))
+	if err != nil {
+		return nil, fmt.Errorf("could not load key %q: %v", label, err)
+	}
+	return newFileSigner(label, privKey)
+}
+
+// Sign implements KeyStore
+func (ks *DirectoryKeyStore) Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signer, err := ks.GetSigner(label)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand.Reader, digest, opts)
+}
+
+// Decrypt implements KeyStore
+func (ks *DirectoryKeyStore) Decrypt(label string, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	signer, err := ks.GetSigner(label)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Decrypt(rand.Reader, ciphertext, opts)
+}
+
+// fileSigner is a key of the DirectoryKeyStore, which is held in memory
+type fileSigner struct {
+	crypto.Signer
+	label string
+}
+
+func newFileSigner(label string, privKey crypto.PrivateKey) (*fileSigner, error) {
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type of key %q", label)
+	}
+	return &fileSigner{Signer: signer, label: label}, nil
+}
+
+func (s *fileSigner) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	decrypter, ok := s.Signer.(crypto.Decrypter)
+	if !ok {
+		return nil, notDecrypter(s.label)
+	}
+	return decrypter.Decrypt(rand, ciphertext, opts)
+}
+
+func (s *fileSigner) Label() string {
+	return s.label
+}
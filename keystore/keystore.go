@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+// Package keystore keeps private keys by label and signs and decrypts with them, so that the keys used to sign
+// tokens and certificate requests do not have to be loaded from files by the services using them. Keys are stored
+// in a directory (DirectoryKeyStore) or in an HSM (Pkcs11KeyStore, which requires the pkcs11 build tag and cgo).
+package keystore
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// KeyStore stores private keys by label
+type KeyStore interface {
+	// GenerateKey generates a key pair of keyType ("rsa", "ecdsa" or "ed25519") with the same key lengths as
+	// crypt.GenerateKeyPair and stores it under label
+	GenerateKey(label, keyType string, keyLength int) (Signer, error)
+	// ImportKey stores the der encoded PKCS8 private key under label
+	ImportKey(label string, pkcs8Der []byte) (Signer, error)
+	// ListKeys returns the labels of the stored keys
+	ListKeys() ([]string, error)
+	// GetSigner returns the key stored under label
+	GetSigner(label string) (Signer, error)
+	// Sign signs the digest with the key stored under label, see crypto.Signer
+	Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	// Decrypt decrypts the ciphertext with the key stored under label, see crypto.Decrypter
+	Decrypt(label string, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error)
+}
+
+// Signer is a key of a KeyStore. It can be passed to jwtauth.NewTokenFactoryWithSigner and
+// crypt.CreateCertificateRequest. Decrypt is only supported by RSA keys
+type Signer interface {
+	crypto.Signer
+	Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error)
+	Label() string
+}
+
+type KeyNotFoundError struct {
+	Label string
+}
+
+func (e KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key %q not found", e.Label)
+}
+
+type KeyExistsError struct {
+	Label string
+}
+
+func (e KeyExistsError) Error() string {
+	return fmt.Sprintf("key %q already exists", e.Label)
+}
+
+var labelReg = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// validateLabel checks the label, which is used as file name by the DirectoryKeyStore
+func validateLabel(label string) error {
+	if !labelReg.MatchString(label) {
+		return fmt.Errorf("invalid key label %q", label)
+	}
+	return nil
+}
+
+// notDecrypter is the error returned when decrypting with a key that only supports signing
+func notDecrypter(label string) error {
+	return fmt.Errorf("key %q does not support decryption, only RSA keys do", label)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"intel/isecl/lib/common/v2/crypt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyStore runs the tests shared by all KeyStore implementations. prefix keeps the labels of different runs
+// apart, since keys in a token are not removed
+func testKeyStore(t *testing.T, ks KeyStore, prefix string) {
+	rsaKey, err := ks.GenerateKey(prefix+"rsa", "rsa", 3072)
+	assert.NoError(t, err)
+	ecKey, err := ks.GenerateKey(prefix+"ecdsa", "ecdsa", 384)
+	assert.NoError(t, err)
+	_, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("test", "", "ecdsa", 384)
+	assert.NoError(t, err)
+	importedKey, err := ks.ImportKey(prefix+"imported", pkcs8Der)
+	assert.NoError(t, err)
+	privKey, _ := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	assert.Equal(t, privKey.(*ecdsa.PrivateKey).Public(), importedKey.Public())
+
+	_, err = ks.GenerateKey(prefix+"rsa", "ecdsa", 384)
+	assert.IsType(t, KeyExistsError{}, err)
+	_, err = ks.GetSigner(prefix + "missing")
+	assert.IsType(t, KeyNotFoundError{}, err)
+	_, err = ks.GenerateKey("../rsa", "rsa", 3072)
+	assert.Error(t, err)
+
+	labels, err := ks.ListKeys()
+	assert.NoError(t, err)
+	assert.Subset(t, labels, []string{prefix + "rsa", prefix + "ecdsa", prefix + "imported"})
+
+	data := []byte("data to sign")
+	digest := sha512.Sum384(data)
+	for _, key := range []Signer{rsaKey, ecKey, importedKey} {
+		sig, err := ks.Sign(key.Label(), digest[:], crypto.SHA384)
+		assert.NoError(t, err, key.Label())
+		assert.NoError(t, crypt.VerifySignature(data, sig, key.Public(), crypto.SHA384), key.Label())
+
+		// signing with the signer of a key loaded again
+		signer, err := ks.GetSigner(key.Label())
+		assert.NoError(t, err)
+		sig, err = crypt.HashAndSign(data, signer, crypto.SHA384)
+		assert.NoError(t, err)
+		assert.NoError(t, crypt.VerifySignature(data, sig, key.Public(), crypto.SHA384), key.Label())
+	}
+
+	pssDigest := sha256.Sum256(data)
+	pssOpts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err := rsaKey.Sign(rand.Reader, pssDigest[:], pssOpts)
+	assert.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPSS(rsaKey.Public().(*rsa.PublicKey), crypto.SHA256, pssDigest[:], sig, pssOpts))
+
+	secret := []byte("data encryption key")
+	encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey.Public().(*rsa.PublicKey), secret, nil)
+	assert.NoError(t, err)
+	decrypted, err := ks.Decrypt(prefix+"rsa", encrypted, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	assert.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+	encrypted, err = rsa.EncryptPKCS1v15(rand.Reader, rsaKey.Public().(*rsa.PublicKey), secret)
+	assert.NoError(t, err)
+	decrypted, err = ks.Decrypt(prefix+"rsa", encrypted, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+	_, err = ks.Decrypt(prefix+"ecdsa", encrypted, nil)
+	assert.Error(t, err)
+
+	// certificate requests and tokens signed with keys of the key store
+	csrDer, err := crypt.CreateCertificateRequest(pkix.Name{CommonName: "keystore"}, "127.0.0.1,localhost", ecKey)
+	assert.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(csrDer)
+	assert.NoError(t, err)
+	assert.NoError(t, csr.CheckSignature())
+	assert.Equal(t, []string{"localhost"}, csr.DNSNames)
+
+	for _, key := range []Signer{rsaKey, ecKey} {
+		certPem := selfSignedCert(t, key)
+		factory, err := jwtauth.NewTokenFactoryWithSigner(key, true, certPem, "AAS JWT Issuer", 0)
+		assert.NoError(t, err)
+		tokenString, err := factory.Create(map[string]string{"name": "admin"}, "admin", 0)
+		assert.NoError(t, err)
+		verifier, err := jwtauth.NewVerifier(certPem, nil, time.Hour)
+		assert.NoError(t, err)
+		claims := map[string]interface{}{}
+		_, err = verifier.ValidateTokenAndGetClaims(tokenString, &claims)
+		assert.NoError(t, err, key.Label())
+		assert.Equal(t, "admin", claims["name"])
+	}
+}
+
+func selfSignedCert(t *testing.T, signer crypto.Signer) []byte {
+	sigAlg, err := crypt.GetSignatureAlgorithm(signer.Public())
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "jwt signing"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		SignatureAlgorithm:    sigAlg,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDirectoryKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks, err := NewDirectoryKeyStore(filepath.Join(dir, "keys"))
+	assert.NoError(t, err)
+	testKeyStore(t, ks, "")
+
+	edKey, err := ks.GenerateKey("ed25519", "ed25519", 0)
+	assert.NoError(t, err)
+	sig, err := ks.Sign("ed25519", []byte("data to sign"), crypto.Hash(0))
+	assert.NoError(t, err)
+	assert.NoError(t, crypt.VerifySignature([]byte("data to sign"), sig, edKey.Public(), 0))
+	labels, err := ks.ListKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ecdsa", "ed25519", "imported", "rsa"}, labels)
+}
+
+func TestDirectoryKeyStoreWithPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks, err := NewDirectoryKeyStore(dir, DirectoryKeyStoreOptions{Password: "password"})
+	assert.NoError(t, err)
+	key, err := ks.GenerateKey("ecdsa", "ecdsa", 384)
+	assert.NoError(t, err)
+
+	keyPem, err := ioutil.ReadFile(filepath.Join(dir, "ecdsa.pem"))
+	assert.NoError(t, err)
+	block, _ := pem.Decode(keyPem)
+	assert.Equal(t, crypt.EncryptedPrivateKeyPemType, block.Type)
+
+	loaded, err := ks.GetSigner("ecdsa")
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), loaded.Public())
+
+	ks, _ = NewDirectoryKeyStore(dir)
+	_, err = ks.GetSigner("ecdsa")
+	assert.Error(t, err)
+	ks, _ = NewDirectoryKeyStore(dir, DirectoryKeyStoreOptions{Password: "wrong password"})
+	_, err = ks.GetSigner("ecdsa")
+	assert.Error(t, err)
+}
@@ -0,0 +1,548 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Pkcs11Options configures the Pkcs11KeyStore
+type Pkcs11Options struct {
+	// Module is the path of the PKCS#11 library of the HSM, for instance /usr/lib/softhsm/libsofthsm2.so
+	Module string
+	// TokenLabel is the label of the token the keys are stored in
+	TokenLabel string
+	// Pin is the user pin of the token
+	Pin string
+}
+
+// Pkcs11KeyStore stores keys in a PKCS#11 token. The private keys are generated or imported as sensitive, so they
+// never leave the token. Key pairs are stored as a private and public key object with the label of the key as
+// CKA_LABEL and CKA_ID. Only RSA and ECDSA keys are supported.
+type Pkcs11KeyStore struct {
+	ctx *pkcs11.Ctx
+	// session is shared by all keys, PKCS#11 sessions must not be used concurrently
+	session pkcs11.SessionHandle
+	mutex   sync.Mutex
+}
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// hashMechanisms maps the hashes to the PKCS#11 hash and MGF1 mechanisms used for RSA-PSS and RSA-OAEP
+var hashMechanisms = map[crypto.Hash][2]uint{
+	crypto.SHA1:   {pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1},
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes of PKCS#1 v1.5 signatures, since CKM_RSA_PKCS signs
+// the DigestInfo rather than the digest
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// NewPkcs11KeyStore loads the PKCS#11 module and logs in to the token. Close has to be called when the key store
+// is no longer used.
+func NewPkcs11KeyStore(options Pkcs11Options) (*Pkcs11KeyStore, error) {
+	ctx := pkcs11.New(options.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load PKCS#11 module %s", options.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("could not initialize PKCS#11 module %s: %v", options.Module, err)
+	}
+	ks := &Pkcs11KeyStore{ctx: ctx}
+	if err := ks.openSession(options); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *Pkcs11KeyStore) openSession(options Pkcs11Options) error {
+	slots, err := ks.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("could not list PKCS#11 slots: %v", err)
+	}
+	for _, slot := range slots {
+		tokenInfo, err := ks.ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimRight(tokenInfo.Label, " \x00") != options.TokenLabel {
+			continue
+		}
+		ks.session, err = ks.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return fmt.Errorf("could not open PKCS#11 session: %v", err)
+		}
+		err = ks.ctx.Login(ks.session, pkcs11.CKU_USER, options.Pin)
+		if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			ks.ctx.CloseSession(ks.session)
+			return fmt.Errorf("could not log in to PKCS#11 token %q: %v", options.TokenLabel, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("PKCS#11 token %q not found", options.TokenLabel)
+}
+
+// Close logs out of the token and unloads the PKCS#11 module
+func (ks *Pkcs11KeyStore) Close() error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.ctx.Logout(ks.session)
+	ks.ctx.CloseSession(ks.session)
+	err := ks.ctx.Finalize()
+	ks.ctx.Destroy()
+	return err
+}
+
+// findObjects returns the handles of the objects matching the template
+func (ks *Pkcs11KeyStore) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := ks.ctx.FindObjectsInit(ks.session, template); err != nil {
+		return nil, fmt.Errorf("could not search PKCS#11 objects: %v", err)
+	}
+	defer ks.ctx.FindObjectsFinal(ks.session)
+	var handles []pkcs11.ObjectHandle
+	for {
+		found, _, err := ks.ctx.FindObjects(ks.session, 100)
+		if err != nil {
+			return nil, fmt.Errorf("could not search PKCS#11 objects: %v", err)
+		}
+		if len(found) == 0 {
+			return handles, nil
+		}
+		handles = append(handles, found...)
+	}
+}
+
+// findKey returns the handle of the key object of class stored under label
+func (ks *Pkcs11KeyStore) findKey(label string, class uint) (pkcs11.ObjectHandle, error) {
+	handles, err := ks.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, KeyNotFoundError{Label: label}
+	}
+	return handles[0], nil
+}
+
+// checkNewLabel checks that label is valid and not used yet
+func (ks *Pkcs11KeyStore) checkNewLabel(label string) error {
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+	_, err := ks.findKey(label, pkcs11.CKO_PRIVATE_KEY)
+	switch err.(type) {
+	case nil:
+		return KeyExistsError{Label: label}
+	case KeyNotFoundError:
+		return nil
+	default:
+		return err
+	}
+}
+
+func curveOid(curve elliptic.Curve) (asn1.ObjectIdentifier, error) {
+	switch curve {
+	case elliptic.P256():
+		return oidNamedCurveP256, nil
+	case elliptic.P384():
+		return oidNamedCurveP384, nil
+	case elliptic.P521():
+		return oidNamedCurveP521, nil
+	}
+	return nil, fmt.Errorf("unsupported elliptic curve %s", curve.Params().Name)
+}
+
+func curveFromParams(ecParams []byte) (elliptic.Curve, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(ecParams, &oid); err != nil {
+		return nil, fmt.Errorf("could not parse elliptic curve parameters: %v", err)
+	}
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		return elliptic.P256(), nil
+	case oid.Equal(oidNamedCurveP384):
+		return elliptic.P384(), nil
+	case oid.Equal(oidNamedCurveP521):
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported elliptic curve %v", oid)
+}
+
+// keyTemplates returns the attributes common to the public and private key objects of a key pair
+func keyTemplates(label string, keyType uint) (public, private []*pkcs11.Attribute) {
+	public = []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, label),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	}
+	private = []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, label),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	}
+	if keyType == pkcs11.CKK_RSA {
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true))
+	}
+	return public, private
+}
+
+// GenerateKey implements KeyStore. The key is generated in the token, ed25519 keys are not supported
+func (ks *Pkcs11KeyStore) GenerateKey(label, keyType string, keyLength int) (Signer, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if err := ks.checkNewLabel(label); err != nil {
+		return nil, err
+	}
+
+	var mechanism uint
+	var public, private []*pkcs11.Attribute
+	switch strings.ToLower(keyType) {
+	case "rsa":
+		if keyLength != 4096 {
+			keyLength = 3072
+		}
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		public, private = keyTemplates(label, pkcs11.CKK_RSA)
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, keyLength),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		)
+	case "ecdsa":
+		// same curves as crypt.GenerateKeyPair
+		curve := elliptic.P384()
+		if keyLength >= 512 {
+			curve = elliptic.P521()
+		}
+		oid, _ := curveOid(curve)
+		ecParams, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+		public, private = keyTemplates(label, pkcs11.CKK_EC)
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams))
+	default:
+		return nil, fmt.Errorf("unsupported key type %q, only rsa and ecdsa keys are supported by the PKCS#11 key store", keyType)
+	}
+
+	_, privHandle, err := ks.ctx.GenerateKeyPair(ks.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key %q: %v", label, err)
+	}
+	return ks.newSigner(label, privHandle)
+}
+
+// ImportKey implements KeyStore. The private key is stored as sensitive and not extractable
+func (ks *Pkcs11KeyStore) ImportKey(label string, pkcs8Der []byte) (Signer, error) {
+	privKey, err := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if err := ks.checkNewLabel(label); err != nil {
+		return nil, err
+	}
+
+	var public, private []*pkcs11.Attribute
+	switch key := privKey.(type) {
+	case *rsa.PrivateKey:
+		key.Precompute()
+		e := big.NewInt(int64(key.E)).Bytes()
+		public, private = keyTemplates(label, pkcs11.CKK_RSA)
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, e),
+		)
+		private = append(private,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, e),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, key.D.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, key.Primes[0].Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, key.Primes[1].Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, key.Precomputed.Dp.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, key.Precomputed.Dq.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, key.Precomputed.Qinv.Bytes()),
+		)
+	case *ecdsa.PrivateKey:
+		oid, err := curveOid(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		ecParams, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		ecPoint, err := asn1.Marshal(elliptic.Marshal(key.Curve, key.X, key.Y))
+		if err != nil {
+			return nil, err
+		}
+		// the private value is padded to the size of the curve
+		d := make([]byte, (key.Curve.Params().BitSize+7)/8)
+		dBytes := key.D.Bytes()
+		copy(d[len(d)-len(dBytes):], dBytes)
+		public, private = keyTemplates(label, pkcs11.CKK_EC)
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, ecPoint),
+		)
+		private = append(private,
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, d),
+		)
+	default:
+		return nil, fmt.Errorf("unsupported private key type, only rsa and ecdsa keys are supported by the PKCS#11 key store")
+	}
+
+	privHandle, err := ks.ctx.CreateObject(ks.session, private)
+	if err != nil {
+		return nil, fmt.Errorf("could not import key %q: %v", label, err)
+	}
+	if _, err = ks.ctx.CreateObject(ks.session, public); err != nil {
+		ks.ctx.DestroyObject(ks.session, privHandle)
+		return nil, fmt.Errorf("could not import public key of %q: %v", label, err)
+	}
+	return ks.newSigner(label, privHandle)
+}
+
+// ListKeys implements KeyStore
+func (ks *Pkcs11KeyStore) ListKeys() ([]string, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	handles, err := ks.findObjects([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)})
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for _, handle := range handles {
+		attrs, err := ks.ctx.GetAttributeValue(ks.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil)})
+		if err != nil {
+			return nil, fmt.Errorf("could not read key label: %v", err)
+		}
+		labels = append(labels, string(attrs[0].Value))
+	}
+	return labels, nil
+}
+
+// GetSigner implements KeyStore
+func (ks *Pkcs11KeyStore) GetSigner(label string) (Signer, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	privHandle, err := ks.findKey(label, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return ks.newSigner(label, privHandle)
+}
+
+// Sign implements KeyStore
+func (ks *Pkcs11KeyStore) Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signer, err := ks.GetSigner(label)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand.Reader, digest, opts)
+}
+
+// Decrypt implements KeyStore
+func (ks *Pkcs11KeyStore) Decrypt(label string, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	signer, err := ks.GetSigner(label)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Decrypt(rand.Reader, ciphertext, opts)
+}
+
+// newSigner reads the public key of the key pair stored under label. The caller has to hold the mutex
+func (ks *Pkcs11KeyStore) newSigner(label string, privHandle pkcs11.ObjectHandle) (*pkcs11Signer, error) {
+	pubHandle, err := ks.findKey(label, pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		return nil, fmt.Errorf("could not find public key of %q: %v", label, err)
+	}
+	attrs, err := ks.ctx.GetAttributeValue(ks.session, pubHandle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key of %q: %v", label, err)
+	}
+
+	signer := &pkcs11Signer{ks: ks, label: label, handle: privHandle}
+	switch keyType := attrs[0].Value; {
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA).Value):
+		attrs, err = ks.ctx.GetAttributeValue(ks.session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read public key of %q: %v", label, err)
+		}
+		signer.pubKey = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC).Value):
+		attrs, err = ks.ctx.GetAttributeValue(ks.session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read public key of %q: %v", label, err)
+		}
+		curve, err := curveFromParams(attrs[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		// CKA_EC_POINT is a DER encoded octet string, though some tokens return the raw point
+		point := attrs[1].Value
+		var unwrapped []byte
+		if rest, err := asn1.Unmarshal(point, &unwrapped); err == nil && len(rest) == 0 {
+			point = unwrapped
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, fmt.Errorf("invalid public key of %q", label)
+		}
+		signer.pubKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		return nil, fmt.Errorf("unsupported key type of %q", label)
+	}
+	return signer, nil
+}
+
+// pkcs11Signer is a key of the Pkcs11KeyStore. The private key operations are performed by the token
+type pkcs11Signer struct {
+	ks     *Pkcs11KeyStore
+	label  string
+	handle pkcs11.ObjectHandle
+	pubKey crypto.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pubKey
+}
+
+func (s *pkcs11Signer) Label() string {
+	return s.label
+}
+
+// Sign signs the digest. RSA keys produce a PKCS#1 v1.5 signature unless opts are *rsa.PSSOptions, ECDSA keys an
+// ASN.1 encoded signature like ecdsa.PrivateKey does
+func (s *pkcs11Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	if hash != 0 && len(digest) != hash.Size() {
+		return nil, fmt.Errorf("digest length %d does not match hash function", len(digest))
+	}
+
+	var mechanism *pkcs11.Mechanism
+	data := digest
+	switch s.pubKey.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			hashMechanism, ok := hashMechanisms[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash function %v for RSA-PSS", hash)
+			}
+			saltLength := pssOpts.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = hash.Size()
+			}
+			params := pkcs11.NewPSSParams(hashMechanism[0], hashMechanism[1], uint(saltLength))
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params)
+		} else {
+			prefix, ok := digestInfoPrefixes[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash function %v for RSA PKCS#1 v1.5 signatures", hash)
+			}
+			data = append(append([]byte{}, prefix...), digest...)
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		}
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	}
+
+	s.ks.mutex.Lock()
+	defer s.ks.mutex.Unlock()
+	if err := s.ks.ctx.SignInit(s.ks.session, []*pkcs11.Mechanism{mechanism}, s.handle); err != nil {
+		return nil, fmt.Errorf("could not sign with key %q: %v", s.label, err)
+	}
+	sig, err := s.ks.ctx.Sign(s.ks.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign with key %q: %v", s.label, err)
+	}
+	if _, ok := s.pubKey.(*ecdsa.PublicKey); ok {
+		// CKM_ECDSA returns r and s concatenated
+		half := len(sig) / 2
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+	}
+	return sig, nil
+}
+
+// Decrypt decrypts the ciphertext with RSA-OAEP if opts are *rsa.OAEPOptions and PKCS#1 v1.5 otherwise
+func (s *pkcs11Signer) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := s.pubKey.(*rsa.PublicKey); !ok {
+		return nil, notDecrypter(s.label)
+	}
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	if oaepOpts, ok := opts.(*rsa.OAEPOptions); ok {
+		hashMechanism, ok := hashMechanisms[oaepOpts.Hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function %v for RSA-OAEP", oaepOpts.Hash)
+		}
+		params := pkcs11.NewOAEPParams(hashMechanism[0], hashMechanism[1], pkcs11.CKZ_DATA_SPECIFIED, oaepOpts.Label)
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)
+	}
+
+	s.ks.mutex.Lock()
+	defer s.ks.mutex.Unlock()
+	if err := s.ks.ctx.DecryptInit(s.ks.session, []*pkcs11.Mechanism{mechanism}, s.handle); err != nil {
+		return nil, fmt.Errorf("could not decrypt with key %q: %v", s.label, err)
+	}
+	data, err := s.ks.ctx.Decrypt(s.ks.session, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt with key %q: %v", s.label, err)
+	}
+	return data, nil
+}
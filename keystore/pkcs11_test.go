@@ -0,0 +1,44 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package keystore
+
+import (
+	"os"
+	"testing"
+
+	"intel/isecl/lib/common/v2/crypt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPkcs11KeyStore runs against a token set up for instance with SoftHSM:
+//
+//	softhsm2-util --init-token --free --label keystore-test --pin 1234 --so-pin 1234
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN_LABEL=keystore-test PKCS11_PIN=1234 \
+//		go test -tags pkcs11 ./keystore
+func TestPkcs11KeyStore(t *testing.T) {
+	options := Pkcs11Options{
+		Module:     os.Getenv("PKCS11_MODULE"),
+		TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+		Pin:        os.Getenv("PKCS11_PIN"),
+	}
+	if options.Module == "" {
+		t.Skip("PKCS11_MODULE is not set")
+	}
+	ks, err := NewPkcs11KeyStore(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+
+	prefix, _ := crypt.GetHexRandomString(4)
+	testKeyStore(t, ks, prefix+"-")
+
+	_, err = ks.GenerateKey(prefix+"-ed25519", "ed25519", 0)
+	assert.Error(t, err)
+}
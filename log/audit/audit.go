@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package audit
+
+import (
+	"context"
+	"time"
+
+	clog "intel/isecl/lib/common/v2/log"
+)
+
+// Decision is the outcome of a request as far as access control is concerned
+type Decision string
+
+const (
+	DecisionAllow Decision = "allow"
+	DecisionDeny  Decision = "deny"
+	DecisionError Decision = "error"
+)
+
+// Event is the audit record of a single request. Category is one of the messages from log/message
+type Event struct {
+	Time       time.Time     `json:"timestamp"`
+	RemoteAddr string        `json:"remote_addr"`
+	Subject    string        `json:"subject,omitempty"`
+	Route      string        `json:"route"`
+	Method     string        `json:"method"`
+	Status     int           `json:"status"`
+	Decision   Decision      `json:"decision"`
+	Latency    time.Duration `json:"latency_ns"`
+	Category   string        `json:"category"`
+	Message    string        `json:"message,omitempty"`
+}
+
+// Fields returns the event as log fields. The keys match the json names of the event
+func (e *Event) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"timestamp":   e.Time.Format(time.RFC3339Nano),
+		"remote_addr": e.RemoteAddr,
+		"route":       e.Route,
+		"method":      e.Method,
+		"status":      e.Status,
+		"decision":    string(e.Decision),
+		"latency_ns":  int64(e.Latency),
+		"category":    e.Category,
+	}
+	if e.Subject != "" {
+		fields["subject"] = e.Subject
+	}
+	if e.Message != "" {
+		fields["message"] = e.Message
+	}
+	return fields
+}
+
+// Log writes the event to the security logger as a single entry. Denied requests are logged as warnings and
+// requests that failed with an error as errors
+func Log(e *Event) {
+	entry := clog.GetSecurityLogger().WithFields(e.Fields())
+	switch e.Decision {
+	case DecisionDeny:
+		entry.Warning(e.Category)
+	case DecisionError:
+		entry.Error(e.Category)
+	default:
+		entry.Info(e.Category)
+	}
+}
+
+type eventKey struct{}
+
+// NewContext returns a context carrying the event, so that handlers further down the chain can add to it
+func NewContext(ctx context.Context, e *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, e)
+}
+
+// FromContext returns the event of the request, or nil if the request is not audited
+func FromContext(ctx context.Context) *Event {
+	if e, ok := ctx.Value(eventKey{}).(*Event); ok {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"intel/isecl/lib/common/v2/log/audit"
+	commLogMsg "intel/isecl/lib/common/v2/log/message"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer, so that streaming handlers keep working when audited
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack forwards to the underlying writer, so that websocket handlers keep working when audited. The status of
+// a hijacked connection is recorded as 101 Switching Protocols
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking the connection")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && sr.status == 0 {
+		sr.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// auditRequest records the outcome of the request on its audit event, if the request is audited. Categories set
+// by the middleware that rejected the request take precedence over the ones derived from the status code
+func auditRequest(r *http.Request, category, message string) {
+	if e := audit.FromContext(r.Context()); e != nil {
+		e.Category = category
+		e.Message = message
+	}
+}
+
+// auditSubject records the authenticated subject on the audit event of the request
+func auditSubject(r *http.Request, subject string) {
+	if e := audit.FromContext(r.Context()); e != nil {
+		e.Subject = subject
+	}
+}
+
+// decisionFromStatus maps the response status to an access decision and a default message category
+func decisionFromStatus(status int) (audit.Decision, string) {
+	switch {
+	case status == http.StatusUnauthorized:
+		return audit.DecisionDeny, commLogMsg.AuthenticationFailed
+	case status == http.StatusForbidden:
+		return audit.DecisionDeny, commLogMsg.UnauthorizedAccess
+	case status == http.StatusTooManyRequests:
+		return audit.DecisionDeny, commLogMsg.PerformanceProblem
+	case status >= http.StatusInternalServerError:
+		return audit.DecisionError, commLogMsg.AppRuntimeErr
+	default:
+		return audit.DecisionAllow, commLogMsg.AuthorizedAccess
+	}
+}
+
+// NewAuditLog returns a middleware that emits one audit event per request once the request has been handled. The
+// event is handed to sink, or written to the security logger with audit.Log if sink is nil. It should be the
+// outermost middleware so that the events of requests rejected by NewTokenAuth, RequirePermissions,
+// RequireRoles and NewRateLimit are recorded with their reason and the authenticated subject.
+func NewAuditLog(sink func(*audit.Event)) mux.MiddlewareFunc {
+	if sink == nil {
+		sink = audit.Log
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			start := time.Now()
+			event := &audit.Event{
+				Time:       start,
+				RemoteAddr: r.RemoteAddr,
+				Route:      r.URL.Path,
+				Method:     r.Method,
+			}
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					event.Route = template
+				}
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(audit.NewContext(r.Context(), event)))
+
+			event.Latency = time.Since(start)
+			event.Status = recorder.status
+			if event.Status == 0 {
+				event.Status = http.StatusOK
+			}
+			decision, category := decisionFromStatus(event.Status)
+			event.Decision = decision
+			if event.Category == "" {
+				event.Category = category
+			}
+			sink(event)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"intel/isecl/lib/common/v2/crypt"
+	"intel/isecl/lib/common/v2/log/audit"
+	commLogMsg "intel/isecl/lib/common/v2/log/message"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+
+	var events []*audit.Event
+	router := mux.NewRouter()
+	router.Use(NewAuditLog(func(e *audit.Event) {
+		events = append(events, e)
+	}))
+	router.Use(NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour))
+	router.Handle("/hosts/{id}", RequireRoles(ct.RoleInfo{Service: "HVS", Name: "Administrator"})(okHandler))
+	router.Handle("/flavors/{id}", RequireRoles(ct.RoleInfo{Service: "HVS", Name: "FlavorManager"})(okHandler))
+
+	serve := func(path, token string) *audit.Event {
+		req := httptest.NewRequest("DELETE", path, nil)
+		req.RemoteAddr = "10.1.1.1:1000"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return events[len(events)-1]
+	}
+
+	e := serve("/hosts/1234", issuer.token(t))
+	assert.Equal(t, "/hosts/{id}", e.Route)
+	assert.Equal(t, "DELETE", e.Method)
+	assert.Equal(t, "10.1.1.1:1000", e.RemoteAddr)
+	assert.Equal(t, "admin", e.Subject)
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.Equal(t, audit.DecisionAllow, e.Decision)
+	assert.Equal(t, commLogMsg.AuthorizedAccess, e.Category)
+	assert.True(t, e.Latency > 0)
+
+	e = serve("/hosts/1234", "")
+	assert.Equal(t, http.StatusUnauthorized, e.Status)
+	assert.Equal(t, audit.DecisionDeny, e.Decision)
+	assert.Equal(t, commLogMsg.AuthenticationFailed, e.Category)
+	assert.Empty(t, e.Subject)
+
+	e = serve("/flavors/1234", issuer.token(t))
+	assert.Equal(t, http.StatusForbidden, e.Status)
+	assert.Equal(t, audit.DecisionDeny, e.Decision)
+	assert.Equal(t, commLogMsg.UnauthorizedAccess, e.Category)
+	assert.Equal(t, "admin", e.Subject)
+
+	assert.Len(t, events, 3)
+}
+
+func TestAuditLogForwardsFlushAndHijack(t *testing.T) {
+	var events []*audit.Event
+	auditLog := NewAuditLog(func(e *audit.Event) {
+		events = append(events, e)
+	})
+
+	rec := httptest.NewRecorder()
+	auditLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		assert.True(t, ok)
+		w.Write([]byte("event"))
+		flusher.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, http.StatusOK, events[0].Status)
+
+	server := httptest.NewServer(auditLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	})))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+}
+
+func TestAuditEventFields(t *testing.T) {
+	e := audit.Event{Route: "/hosts", Method: "GET", Status: http.StatusInternalServerError, Decision: audit.DecisionError}
+	fields := e.Fields()
+	assert.Equal(t, "error", fields["decision"])
+	assert.Equal(t, http.StatusInternalServerError, fields["status"])
+	assert.NotContains(t, fields, "subject")
+
+	// logging must not panic for any decision
+	audit.Log(&e)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"encoding/json"
+	"intel/isecl/lib/common/v2/auth"
+	"intel/isecl/lib/common/v2/context"
+	commLogMsg "intel/isecl/lib/common/v2/log/message"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// errorResponse is the body written by the authorization middleware when a request is rejected
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, errorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorCode, Message: message})
+}
+
+func writeForbidden(w http.ResponseWriter, r *http.Request, required interface{}) {
+	log.Errorf("request to %s %s does not have the required privileges %v", r.Method, r.URL.Path, required)
+	slog.Warningf("%s: %s %s requested from %s: ", commLogMsg.UnauthorizedAccess, r.Method, r.URL.Path, r.RemoteAddr)
+	auditRequest(r, commLogMsg.UnauthorizedAccess, "caller does not have the required privileges")
+	writeErrorResponse(w, http.StatusForbidden, "forbidden", "caller does not have the privileges required for this request")
+}
+
+// RequirePermissions returns a middleware that only lets requests through if the permissions in the token allow
+// all of the rules for the service. It has to be used after NewTokenAuth. The permission context map is stored
+// on the request and can be retrieved by the handler with context.GetPermissionsContext.
+func RequirePermissions(service string, rules ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			privileges, err := context.GetUserPermissions(r)
+			if err != nil {
+				log.WithError(err).Error("could not get user permissions from request context")
+				writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "request is not authenticated")
+				slog.Warningf("%s: %s %s requested from %s: ", commLogMsg.UnauthorizedAccess, r.Method, r.URL.Path, r.RemoteAddr)
+				return
+			}
+
+			reqPermissions := ct.PermissionInfo{Service: service, Rules: rules}
+			permissionsContext, found := auth.ValidatePermissionAndGetPermissionsContext(privileges, reqPermissions, true)
+			if !found {
+				writeForbidden(w, r, rules)
+				return
+			}
+
+			r = context.SetPermissionsContext(r, permissionsContext)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRoles returns a middleware that only lets requests through if the token carries at least one of the
+// roles. It has to be used after NewTokenAuth. The role context map is stored on the request and can be
+// retrieved by the handler with context.GetRolesContext.
+func RequireRoles(roles ...ct.RoleInfo) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			privileges, err := context.GetUserRoles(r)
+			if err != nil {
+				log.WithError(err).Error("could not get user roles from request context")
+				writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "request is not authenticated")
+				slog.Warningf("%s: %s %s requested from %s: ", commLogMsg.UnauthorizedAccess, r.Method, r.URL.Path, r.RemoteAddr)
+				return
+			}
+
+			rolesContext, found := auth.ValidatePermissionAndGetRoleContext(privileges, roles, true)
+			if !found {
+				writeForbidden(w, r, roles)
+				return
+			}
+
+			r = context.SetRolesContext(r, rolesContext)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"encoding/json"
+	"intel/isecl/lib/common/v2/context"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithPrivileges(handler http.Handler, roles []ct.RoleInfo, permissions []ct.PermissionInfo) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	if roles != nil {
+		req = context.SetUserRoles(req, roles)
+	}
+	if permissions != nil {
+		req = context.SetUserPermissions(req, permissions)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequirePermissions(t *testing.T) {
+	var permissionsContext *map[string]ct.PermissionInfo
+	handler := RequirePermissions("HVS", "hosts:search")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		permissionsContext, err = context.GetPermissionsContext(r)
+		assert.NoError(t, err)
+	}))
+
+	rec := serveWithPrivileges(handler, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serveWithPrivileges(handler, nil, []ct.PermissionInfo{{Service: "HVS", Rules: []string{"flavors:search"}}})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	body := errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "forbidden", body.Error)
+
+	rec = serveWithPrivileges(handler, nil, []ct.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, permissionsContext, "permission without context is not restricted")
+
+	rec = serveWithPrivileges(handler, nil, []ct.PermissionInfo{{Service: "HVS", Context: "host_id=1234", Rules: []string{"hosts:search"}}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, *permissionsContext, "host_id=1234")
+}
+
+func TestRequirePermissionsDoesNotModifyRules(t *testing.T) {
+	handler := RequirePermissions("HVS", "hosts:search", "hosts:retrieve")(okHandler)
+	permissions := []ct.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}}
+
+	for i := 0; i < 3; i++ {
+		rec := serveWithPrivileges(handler, nil, permissions)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := serveWithPrivileges(handler, nil, []ct.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:search"}}})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestRequireRoles(t *testing.T) {
+	handler := RequireRoles(ct.RoleInfo{Service: "HVS", Name: "Administrator"}, ct.RoleInfo{Service: "HVS", Name: "HostManager"})(okHandler)
+
+	rec := serveWithPrivileges(handler, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serveWithPrivileges(handler, []ct.RoleInfo{{Service: "WLS", Name: "Administrator"}}, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveWithPrivileges(handler, []ct.RoleInfo{{Service: "HVS", Name: "HostManager"}}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"fmt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	"net/http"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// error codes defined in RFC 6750 section 3.1
+const (
+	bearerInvalidRequest = "invalid_request"
+	bearerInvalidToken   = "invalid_token"
+)
+
+// tokenCheckUnavailable is reported when the token could not be checked because of a failure on the server side.
+// It is not an RFC 6750 error code since the token itself is not known to be bad, the client should retry later
+// rather than fetch a new token.
+const tokenCheckUnavailable = "temporarily_unavailable"
+
+// TokenAuthOptions changes how the middleware returned by NewTokenAuth responds to requests it rejects
+type TokenAuthOptions struct {
+	// Realm is added to the WWW-Authenticate header if set
+	Realm string
+	// JSONErrorBody adds a json body with the error code and description to the responses of rejected requests
+	JSONErrorBody bool
+	// VerifyOptions is the claim validation policy of the jwt verifier, it is applied every time the verifier is
+	// reloaded. A RevocationStore in it is consulted for every token
+	VerifyOptions jwtauth.VerifyOptions
+}
+
+// bearerError describes why a request was rejected. An empty code means that the request had no credentials,
+// in which case RFC 6750 asks for the challenge to be sent without an error code.
+type bearerError struct {
+	code        string
+	description string
+}
+
+// classifyTokenError maps the error returned by token validation to the RFC 6750 error reported to the client. The
+// description is kept generic enough to not tell the client more than whether to fetch a new token.
+func classifyTokenError(err error) bearerError {
+	switch e := err.(type) {
+	case *jwtauth.MatchingCertNotFoundError:
+		return bearerError{bearerInvalidToken, "the token is signed by an unknown key"}
+	case *jwtauth.MatchingCertJustExpired:
+		return bearerError{bearerInvalidToken, "the certificate of the key the token is signed with has expired"}
+	case *jwtauth.VerifierExpiredError:
+		return bearerError{bearerInvalidToken, "the key the token is signed with could not be verified"}
+	case *jwtauth.TokenExpiredError:
+		return bearerError{bearerInvalidToken, "the token has expired"}
+	case *jwtauth.TokenNotValidYetError:
+		return bearerError{bearerInvalidToken, "the token is not valid yet"}
+	case *jwtauth.TokenRevokedError:
+		return bearerError{bearerInvalidToken, "the token has been revoked"}
+	case *jwtauth.RevocationCheckError:
+		return bearerError{tokenCheckUnavailable, "the revocation status of the token could not be checked"}
+	case *jwtauth.AlgorithmNotAllowedError:
+		return bearerError{bearerInvalidToken, "the token is signed with an algorithm that is not allowed"}
+	case *jwtauth.InvalidIssuerError:
+		return bearerError{bearerInvalidToken, "the token is issued by an untrusted issuer"}
+	case *jwtauth.InvalidAudienceError:
+		return bearerError{bearerInvalidToken, "the token is not intended for this service"}
+	case *jwtauth.MissingClaimError:
+		return bearerError{bearerInvalidToken, fmt.Sprintf("the token is missing the %s claim", e.Claim)}
+	case *jwtauth.TokenLifetimeExceededError:
+		return bearerError{bearerInvalidToken, "the token lifetime exceeds the allowed maximum"}
+	case *jwt.ValidationError:
+		switch {
+		case e.Errors&jwt.ValidationErrorMalformed != 0:
+			return bearerError{bearerInvalidToken, "the token is malformed"}
+		case e.Errors&jwt.ValidationErrorExpired != 0:
+			return bearerError{bearerInvalidToken, "the token has expired"}
+		case e.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return bearerError{bearerInvalidToken, "the token signature is invalid"}
+		}
+	}
+	return bearerError{bearerInvalidToken, "the token is invalid"}
+}
+
+// challenge returns the value of the WWW-Authenticate header for the error
+func (e bearerError) challenge(realm string) string {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+	if e.code != "" {
+		params = append(params, fmt.Sprintf("error=%q", e.code))
+		params = append(params, fmt.Sprintf("error_description=%q", e.description))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// writeBearerError writes the 401 response for a request rejected by the token middleware. Failures on the
+// server side get a 503 response without a challenge, so that the client does not discard a valid token.
+func writeBearerError(w http.ResponseWriter, opts TokenAuthOptions, e bearerError) {
+	status := http.StatusUnauthorized
+	switch e.code {
+	case bearerInvalidRequest:
+		status = http.StatusBadRequest
+	case tokenCheckUnavailable:
+		status = http.StatusServiceUnavailable
+	}
+	if status != http.StatusServiceUnavailable {
+		w.Header().Set("WWW-Authenticate", e.challenge(opts.Realm))
+	}
+	if !opts.JSONErrorBody {
+		w.WriteHeader(status)
+		return
+	}
+	code := e.code
+	if code == "" {
+		code = "unauthorized"
+	}
+	writeErrorResponse(w, status, code, e.description)
+}
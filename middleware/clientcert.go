@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"crypto/x509"
+	"fmt"
+	"intel/isecl/lib/common/v2/context"
+	commLogMsg "intel/isecl/lib/common/v2/log/message"
+	cos "intel/isecl/lib/common/v2/os"
+	"intel/isecl/lib/common/v2/search"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ClientCertPrivileges are the roles and permissions granted to a client authenticated with a certificate
+type ClientCertPrivileges struct {
+	Roles       []ct.RoleInfo       `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Permissions []ct.PermissionInfo `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+// ClientCertMapFn returns the privileges of a client whose certificate chain has been verified. Returning an error
+// or nil privileges rejects the request with 403 Forbidden
+type ClientCertMapFn func(cert *x509.Certificate) (*ClientCertPrivileges, error)
+
+// ClientCertIdentities returns the identities a client certificate can be mapped by. These are the common name
+// as "CN=<name>" followed by the subject alternative names as "DNS:<name>", "IP:<address>", "email:<address>"
+// and "URI:<uri>"
+func ClientCertIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, "CN="+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, "IP:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, "URI:"+uri.String())
+	}
+	return identities
+}
+
+// NewClientCertMapping returns a ClientCertMapFn that grants privileges by the identities of the client
+// certificate (see ClientCertIdentities). The keys of the mapping are matched against the identities with
+// search.WildcardMatched, so "CN=host-agent-*" or "DNS:*.intel.com" match a group of clients. The privileges of
+// all matching keys are combined.
+func NewClientCertMapping(mapping map[string]ClientCertPrivileges) ClientCertMapFn {
+	patterns := make([]string, 0, len(mapping))
+	for pattern := range mapping {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	return func(cert *x509.Certificate) (*ClientCertPrivileges, error) {
+		identities := ClientCertIdentities(cert)
+		var privileges *ClientCertPrivileges
+		for _, pattern := range patterns {
+			for _, identity := range identities {
+				if !search.WildcardMatched(identity, pattern) {
+					continue
+				}
+				if privileges == nil {
+					privileges = &ClientCertPrivileges{}
+				}
+				privileges.Roles = append(privileges.Roles, mapping[pattern].Roles...)
+				privileges.Permissions = append(privileges.Permissions, mapping[pattern].Permissions...)
+				break
+			}
+		}
+		if privileges == nil {
+			return nil, fmt.Errorf("no privileges are mapped to client certificate with identities %v", identities)
+		}
+		return privileges, nil
+	}
+}
+
+// ClientCertAuthOptions changes how the middleware returned by NewClientCertAuth responds to requests it rejects
+type ClientCertAuthOptions struct {
+	// JSONErrorBody adds a json body with the error code and description to 401 responses, like
+	// TokenAuthOptions.JSONErrorBody does for the token middleware
+	JSONErrorBody bool
+}
+
+// clientCertAuth holds the trusted CAs client certificates are verified against. The CAs are reloaded when the
+// trusted CA directory changes.
+type clientCertAuth struct {
+	trustedCAsDir string
+	mapFn         ClientCertMapFn
+
+	mtx       sync.Mutex
+	roots     *x509.CertPool
+	dirState  string
+	lastCheck time.Time
+}
+
+func (cca *clientCertAuth) getRoots() *x509.CertPool {
+	cca.mtx.Lock()
+	defer cca.mtx.Unlock()
+
+	if cca.roots != nil && time.Since(cca.lastCheck) < certDirCheckInterval {
+		return cca.roots
+	}
+	cca.lastCheck = time.Now()
+	dirState := pemDirState(cca.trustedCAsDir)
+	if cca.roots != nil && dirState == cca.dirState {
+		return cca.roots
+	}
+
+	rootPems, err := cos.GetDirFileContents(cca.trustedCAsDir, "*.pem")
+	if err != nil {
+		log.WithError(err).Error("could not load trusted CA certificates for client certificate authentication")
+	}
+	roots := x509.NewCertPool()
+	for _, rootPem := range rootPems {
+		roots.AppendCertsFromPEM(rootPem)
+	}
+	cca.roots = roots
+	cca.dirState = dirState
+	return roots
+}
+
+// verify checks the certificate chain presented by the client and returns the client certificate
+func (cca *clientCertAuth) verify(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate provided")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	cert := r.TLS.PeerCertificates[0]
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         cca.getRoots(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client certificate with subject %s is not trusted: %v", cert.Subject, err)
+	}
+	return cert, nil
+}
+
+// NewClientCertAuth returns a middleware that authenticates requests by the TLS client certificate. The chain
+// presented by the client has to verify against the CAs in trustedCAsDir, then mapFn decides the roles and
+// permissions of the client. These are stored on the request the same way NewTokenAuth does, so the
+// authorization middleware and handlers work with either. The server has to request client certificates, i.e.
+// tls.Config.ClientAuth must be at least tls.RequestClientCert. An optional ClientCertAuthOptions adds a json
+// error body to 401 responses.
+func NewClientCertAuth(trustedCAsDir string, mapFn ClientCertMapFn, options ...ClientCertAuthOptions) mux.MiddlewareFunc {
+	cca := &clientCertAuth{trustedCAsDir: trustedCAsDir, mapFn: mapFn}
+	var opts ClientCertAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			cert, err := cca.verify(r)
+			if err != nil {
+				log.WithError(err).Error("client certificate authentication failure")
+				if !opts.JSONErrorBody {
+					w.WriteHeader(http.StatusUnauthorized)
+				} else if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+					writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "no client certificate provided")
+				} else {
+					writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "the client certificate is not trusted")
+				}
+				slog.Warningf("%s: Invalid client certificate, requested from %s: ", commLogMsg.AuthenticationFailed, r.RemoteAddr)
+				auditRequest(r, commLogMsg.AuthenticationFailed, "invalid client certificate")
+				return
+			}
+			subject := "CN=" + cert.Subject.CommonName
+			auditSubject(r, subject)
+
+			var privileges *ClientCertPrivileges
+			if cca.mapFn != nil {
+				privileges, err = cca.mapFn(cert)
+			}
+			if err != nil || privileges == nil {
+				log.WithError(err).Errorf("no privileges for client certificate %s", subject)
+				writeForbidden(w, r, subject)
+				return
+			}
+
+			r = context.SetIdentity(r, &context.Identity{
+				Subject:     subject,
+				Issuer:      cert.Issuer.String(),
+				ExpiresAt:   cert.NotAfter,
+				Roles:       privileges.Roles,
+				Permissions: privileges.Permissions,
+				AuthMethod:  context.AuthMethodClientCert,
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
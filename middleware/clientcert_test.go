@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"intel/isecl/lib/common/v2/context"
+	"intel/isecl/lib/common/v2/crypt"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (ca *testCA) issueClient(t *testing.T, commonName string, dnsNames ...string) *x509.Certificate {
+	_, pubKey, err := crypt.GenerateKeyPair("ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, pubKey, ca.privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(certDer)
+	return cert
+}
+
+func serveWithClientCert(handler http.Handler, certs ...*x509.Certificate) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	if certs != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestClientCertAuth(t *testing.T) {
+	_, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	trustedCA := newTestCA(t)
+	untrustedCA := newTestCA(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(trustedCA.certPem, trustedCAsDir))
+
+	mapping := NewClientCertMapping(map[string]ClientCertPrivileges{
+		"CN=host-agent-*": {
+			Roles: []ct.RoleInfo{{Service: "HVS", Name: "HostAgent"}},
+		},
+		"DNS:*.intel.com": {
+			Permissions: []ct.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:retrieve"}}},
+		},
+	})
+	var roles []ct.RoleInfo
+	var permissions []ct.PermissionInfo
+	handler := NewClientCertAuth(trustedCAsDir, mapping)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles, _ = context.GetUserRoles(r)
+		permissions, _ = context.GetUserPermissions(r)
+	}))
+
+	rec := serveWithClientCert(handler)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, serveWithClientCert(handler, untrustedCA.issueClient(t, "host-agent-1")).Code)
+	rec = serveWithClientCert(handler, trustedCA.issueClient(t, "workload-agent"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	body := errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "forbidden", body.Error)
+
+	assert.Equal(t, http.StatusOK, serveWithClientCert(handler, trustedCA.issueClient(t, "host-agent-1", "host1.intel.com")).Code)
+	assert.Equal(t, []ct.RoleInfo{{Service: "HVS", Name: "HostAgent"}}, roles)
+	assert.Equal(t, []string{"hosts:retrieve"}, permissions[0].Rules)
+
+	// the json error body matches the one of the token middleware
+	handler = NewClientCertAuth(trustedCAsDir, mapping, ClientCertAuthOptions{JSONErrorBody: true})(okHandler)
+	rec = serveWithClientCert(handler)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	body = errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, errorResponse{Error: "unauthorized", Message: "no client certificate provided"}, body)
+	rec = serveWithClientCert(handler, untrustedCA.issueClient(t, "host-agent-1"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	body = errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, errorResponse{Error: "unauthorized", Message: "the client certificate is not trusted"}, body)
+}
+
+func TestClientCertIdentities(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issueClient(t, "host-agent-1", "host1.intel.com")
+	assert.Equal(t, []string{"CN=host-agent-1", "DNS:host1.intel.com"}, ClientCertIdentities(cert))
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"encoding/json"
+	"intel/isecl/lib/common/v2/auth"
+	"intel/isecl/lib/common/v2/context"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"strings"
+)
+
+// explainResponse is the body written by the handler returned from NewAuthzExplainHandler
+type explainResponse struct {
+	Permissions *auth.Trace `json:"permissions,omitempty"`
+	Roles       *auth.Trace `json:"roles,omitempty"`
+}
+
+// AuthzExplainOptions configures NewAuthzExplainHandler
+type AuthzExplainOptions struct {
+	// Enabled has to be set for the handler to explain anything. It should only be set on debug deployments, since
+	// the explanation tells the caller how authorization decisions are made
+	Enabled bool
+}
+
+// NewAuthzExplainHandler returns a handler that explains whether the privileges of the caller satisfy a
+// requirement, using auth.ExplainPermissions and auth.ExplainRoles. The requirement is taken from the query:
+//
+//	?service=HVS&rule=hosts:search&rule=hosts:delete[&mode=any-of]&role=HVS:Administrator
+//
+// The handler has to be used after NewTokenAuth or NewClientCertAuth. It is a troubleshooting aid that responds
+// with 404 Not Found unless it is enabled with AuthzExplainOptions.
+func NewAuthzExplainHandler(options ...AuthzExplainOptions) http.Handler {
+	opts := AuthzExplainOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !opts.Enabled {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		rules := query["rule"]
+		roles := query["role"]
+		if len(rules) == 0 && len(roles) == 0 {
+			writeErrorResponse(w, http.StatusBadRequest, "bad_request", "at least one rule or role query parameter is required")
+			return
+		}
+
+		response := explainResponse{}
+		if len(rules) > 0 {
+			permissions, err := context.GetUserPermissions(r)
+			if err != nil {
+				writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "request is not authenticated")
+				return
+			}
+			req := auth.PermissionRequirement{Service: query.Get("service"), Rules: rules}
+			if query.Get("mode") == auth.AnyOf.String() {
+				req.Mode = auth.AnyOf
+			}
+			response.Permissions = auth.ExplainPermissions(permissions, req)
+		}
+		if len(roles) > 0 {
+			userRoles, err := context.GetUserRoles(r)
+			if err != nil {
+				writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "request is not authenticated")
+				return
+			}
+			var reqRoles []ct.RoleInfo
+			for _, role := range roles {
+				parts := strings.SplitN(role, ":", 2)
+				if len(parts) != 2 {
+					writeErrorResponse(w, http.StatusBadRequest, "bad_request", "roles have to be given as service:name")
+					return
+				}
+				reqRoles = append(reqRoles, ct.RoleInfo{Service: parts[0], Name: parts[1]})
+			}
+			response.Roles = auth.ExplainRoles(userRoles, reqRoles)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
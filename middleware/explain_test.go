@@ -0,0 +1,49 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"encoding/json"
+	"intel/isecl/lib/common/v2/auth"
+	"intel/isecl/lib/common/v2/context"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthzExplainHandler(t *testing.T) {
+	handler := NewAuthzExplainHandler(AuthzExplainOptions{Enabled: true})
+	explain := func(query string, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/debug/authz?"+query, nil)
+		if authenticated {
+			req = context.SetUserRoles(req, []ct.RoleInfo{{Service: "HVS", Name: "Administrator"}})
+			req = context.SetUserPermissions(req, []ct.PermissionInfo{{Service: "HVS", Rules: []string{"hosts:*"}}})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, explain("service=HVS", true).Code)
+	// the handler is disabled unless enabled explicitly
+	rec := httptest.NewRecorder()
+	NewAuthzExplainHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/authz?service=HVS&rule=hosts:search", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, explain("service=HVS&rule=hosts:search", false).Code)
+	assert.Equal(t, http.StatusBadRequest, explain("role=Administrator", true).Code)
+
+	rec = explain("service=HVS&rule=hosts:search&rule=flavors:search&mode=any-of&role=HVS:HostManager", true)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	response := explainResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, auth.DecisionAllow, response.Permissions.Decision)
+	assert.Equal(t, "any-of", response.Permissions.Mode)
+	assert.Equal(t, []string{"flavors:search"}, response.Permissions.Unsatisfied)
+	assert.Equal(t, auth.DecisionDeny, response.Roles.Decision)
+}
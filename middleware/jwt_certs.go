@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	cos "intel/isecl/lib/common/v2/os"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	jwtCertDownloadTimeout time.Duration = 30 * time.Second
+	jwtCertMaxResponseSize int64         = 1 << 20
+)
+
+// NewJwtCertRetriever returns a function that can be passed to NewTokenAuth to download the jwt signing
+// certificates of the token issuer from jwtCertUrl (for instance https://aas.server:8444/aas/noauth/jwt-certificates)
+// and save them to signingCertsDir
+func NewJwtCertRetriever(jwtCertUrl, signingCertsDir, trustedCAsDir string) RetriveJwtCertFn {
+	return func() error {
+		return retrieveAndSaveTrustedJwtSigningCerts(jwtCertUrl, signingCertsDir, trustedCAsDir)
+	}
+}
+
+// retrieveAndSaveTrustedJwtSigningCerts downloads the pem encoded jwt signing certificates over a TLS connection
+// trusted by the CAs in trustedCAsDir. Each signing certificate has to chain up to one of the trusted CAs.
+// The ones that do are saved along with their intermediates to signingCertsDir.
+func retrieveAndSaveTrustedJwtSigningCerts(jwtCertUrl, signingCertsDir, trustedCAsDir string) error {
+	if jwtCertUrl == "" {
+		return fmt.Errorf("url to retrieve jwt signing certificates is not configured")
+	}
+
+	rootPems, err := cos.GetDirFileContents(trustedCAsDir, "*.pem")
+	if err != nil {
+		return fmt.Errorf("could not load trusted CA certificates: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	for _, rootPem := range rootPems {
+		rootCAs.AppendCertsFromPEM(rootPem)
+	}
+
+	certsPem, err := downloadJwtSigningCerts(jwtCertUrl, rootCAs)
+	if err != nil {
+		return err
+	}
+
+	var leafCerts []*x509.Certificate
+	intermediates := x509.NewCertPool()
+	for block, rest := pem.Decode(certsPem); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("could not parse jwt signing certificate: %v", err)
+		}
+		if cert.IsCA {
+			intermediates.AddCert(cert)
+		} else {
+			leafCerts = append(leafCerts, cert)
+		}
+	}
+	if len(leafCerts) == 0 {
+		return fmt.Errorf("no jwt signing certificates found in response from %s", jwtCertUrl)
+	}
+
+	saved := 0
+	for _, cert := range leafCerts {
+		chains, err := cert.Verify(x509.VerifyOptions{
+			Roots:         rootCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			log.WithError(err).Errorf("jwt signing certificate with subject %s is not trusted", cert.Subject)
+			continue
+		}
+		// save the signing certificate along with the intermediates so that the verifier can build the chain.
+		// The root at the end of the chain is already in the trusted CA directory.
+		var chainPem []byte
+		for _, chainCert := range chains[0][:len(chains[0])-1] {
+			chainPem = append(chainPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chainCert.Raw})...)
+		}
+		if err = crypt.SavePemCertWithShortSha1FileName(chainPem, signingCertsDir); err != nil {
+			return err
+		}
+		saved++
+	}
+	if saved == 0 {
+		return fmt.Errorf("none of the jwt signing certificates retrieved from %s are trusted", jwtCertUrl)
+	}
+	return nil
+}
+
+func downloadJwtSigningCerts(jwtCertUrl string, rootCAs *x509.CertPool) ([]byte, error) {
+	req, err := http.NewRequest("GET", jwtCertUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request to retrieve jwt signing certificates: %v", err)
+	}
+	req.Header.Set("Accept", "application/x-pem-file")
+
+	client := &http.Client{
+		Timeout: jwtCertDownloadTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: false,
+				RootCAs:            rootCAs,
+			},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve jwt signing certificates from %s: %v", jwtCertUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not retrieve jwt signing certificates from %s. HTTP Status Code: %d", jwtCertUrl, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, jwtCertMaxResponseSize))
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"intel/isecl/lib/common/v2/crypt"
+	cos "intel/isecl/lib/common/v2/os"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert    *x509.Certificate
+	privKey crypto.PrivateKey
+	certPem []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	certDer, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("Test Root CA", "", "ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(certDer)
+	privKey, _ := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	return &testCA{cert: cert, privKey: privKey, certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})}
+}
+
+// issue returns a pem encoded jwt signing certificate signed by the CA
+func (ca *testCA) issue(t *testing.T) []byte {
+	_, pubKey, err := crypt.GenerateKeyPair("ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "AAS JWT Signing Certificate"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, pubKey, ca.privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+}
+
+func TestRetrieveJwtSigningCerts(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	trustedCA := newTestCA(t)
+	untrustedCA := newTestCA(t)
+	trustedCert := trustedCA.issue(t)
+	untrustedCert := untrustedCA.issue(t)
+
+	response := trustedCert
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	retrieve := NewJwtCertRetriever(server.URL+"/aas/noauth/jwt-certificates", signingCertsDir, trustedCAsDir)
+
+	// the TLS certificate of the server is not trusted yet
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(trustedCA.certPem, trustedCAsDir))
+	assert.Error(t, retrieve())
+
+	serverCertPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(serverCertPem, trustedCAsDir))
+	assert.NoError(t, retrieve())
+
+	savedCerts, err := cos.GetDirFileContents(signingCertsDir, "*.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{trustedCert}, savedCerts)
+
+	// certificates that do not chain up to a trusted CA are not saved
+	response = untrustedCert
+	assert.Error(t, retrieve())
+	savedCerts, err = cos.GetDirFileContents(signingCertsDir, "*.pem")
+	assert.NoError(t, err)
+	assert.Len(t, savedCerts, 1)
+}
+
+func TestRetrieveJwtSigningCertsRequiresUrl(t *testing.T) {
+	assert.Error(t, NewJwtCertRetriever("", "", "")())
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"intel/isecl/lib/common/v2/context"
+	"intel/isecl/lib/common/v2/external-artifacts/time/rate"
+	commLogMsg "intel/isecl/lib/common/v2/log/message"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultRateLimitIdleTimeout time.Duration = 10 * time.Minute
+
+// RateLimitKeyFn returns the key requests are grouped by for rate limiting. Requests for which an empty key is
+// returned are grouped by remote IP address.
+type RateLimitKeyFn func(r *http.Request) string
+
+// KeyByRemoteIP groups requests by the IP address of the client
+func KeyByRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByIdentity groups requests by the subject of the authenticated caller, so that a user is limited across
+// all the addresses it connects from. The identity is only known once the request has been authenticated, so
+// the rate limit has to be mounted after NewTokenAuth or NewClientCertAuth. Requests that are not authenticated,
+// like those to login and token endpoints, are grouped by remote IP address.
+func KeyByIdentity(r *http.Request) string {
+	identity, err := context.GetIdentity(r)
+	if err != nil || identity.Subject == "" {
+		return ""
+	}
+	return "sub:" + identity.Subject
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one limiter per key. Limiters that have not been used for idleTimeout are evicted so that
+// the map does not grow with every client that has ever connected.
+type rateLimiter struct {
+	limit       rate.Limit
+	burst       int
+	keyFn       RateLimitKeyFn
+	idleTimeout time.Duration
+
+	mtx         sync.Mutex
+	limiters    map[string]*limiterEntry
+	lastCleanup time.Time
+}
+
+func newRateLimiter(limit rate.Limit, burst int, keyFn RateLimitKeyFn, idleTimeout time.Duration) *rateLimiter {
+	if keyFn == nil {
+		keyFn = KeyByRemoteIP
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultRateLimitIdleTimeout
+	}
+	return &rateLimiter{
+		limit:       limit,
+		burst:       burst,
+		keyFn:       keyFn,
+		idleTimeout: idleTimeout,
+		limiters:    make(map[string]*limiterEntry),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (rl *rateLimiter) getLimiter(key string, now time.Time) *rate.Limiter {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	if now.Sub(rl.lastCleanup) > rl.idleTimeout {
+		for k, entry := range rl.limiters {
+			if now.Sub(entry.lastSeen) > rl.idleTimeout {
+				delete(rl.limiters, k)
+			}
+		}
+		rl.lastCleanup = now
+	}
+
+	entry, found := rl.limiters[key]
+	if !found {
+		entry = &limiterEntry{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// reserve takes a token from the limiter of the key. It returns 0 if the request can go ahead, otherwise how long
+// the client should wait before trying again.
+func (rl *rateLimiter) reserve(key string, now time.Time) time.Duration {
+	reservation := rl.getLimiter(key, now).ReserveN(now, 1)
+	if !reservation.OK() {
+		return rate.InfDuration
+	}
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// the request is rejected, so it should not use up the token it reserved
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// NewRateLimit returns a middleware that allows limit requests per second with bursts of up to burst requests
+// for each key returned by keyFn (KeyByRemoteIP if nil). Requests over the limit are rejected with 429 Too Many
+// Requests and a Retry-After header. Keys not seen for idleTimeout are forgotten.
+func NewRateLimit(limit rate.Limit, burst int, keyFn RateLimitKeyFn, idleTimeout time.Duration) mux.MiddlewareFunc {
+	rl := newRateLimiter(limit, burst, keyFn, idleTimeout)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key := rl.keyFn(r)
+			if key == "" {
+				key = KeyByRemoteIP(r)
+			}
+
+			delay := rl.reserve(key, time.Now())
+			if delay > 0 {
+				log.Warningf("%s: rate limit exceeded for %s %s requested from %s", commLogMsg.PerformanceProblem, r.Method, r.URL.Path, r.RemoteAddr)
+				auditRequest(r, commLogMsg.PerformanceProblem, "rate limit exceeded")
+				if delay != rate.InfDuration {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				}
+				writeErrorResponse(w, http.StatusTooManyRequests, "too_many_requests", "request rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"intel/isecl/lib/common/v2/context"
+	"intel/isecl/lib/common/v2/external-artifacts/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveFrom(handler http.Handler, remoteAddr, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/aas/token", nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitByRemoteIP(t *testing.T) {
+	handler := NewRateLimit(rate.Every(time.Minute), 2, nil, 0)(okHandler)
+
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "10.1.1.1:1000", "").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "10.1.1.1:1001", "").Code)
+
+	rec := serveFrom(handler, "10.1.1.1:1002", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	retryAfter := rec.Header().Get("Retry-After")
+	assert.NotEmpty(t, retryAfter)
+	assert.True(t, retryAfter == "60" || retryAfter == "59", retryAfter)
+
+	// other clients have their own limit
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "10.1.1.2:1000", "").Code)
+}
+
+func TestRateLimitByIdentity(t *testing.T) {
+	issuer := newTestIssuer(t)
+	handler := NewRateLimit(rate.Every(time.Minute), 1, KeyByIdentity, 0)(okHandler)
+	serveAs := func(remoteAddr, subject string) int {
+		req := httptest.NewRequest("GET", "/hvs/v2/hosts", nil)
+		req.RemoteAddr = remoteAddr
+		req = context.SetIdentity(req, &context.Identity{Subject: subject})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serveAs("10.1.1.1:1000", "admin"))
+	// same subject from a different address is limited
+	assert.Equal(t, http.StatusTooManyRequests, serveAs("10.1.1.2:1000", "admin"))
+	assert.Equal(t, http.StatusOK, serveAs("10.1.1.2:1000", "other"))
+
+	// bearer tokens are not trusted before they are verified, unauthenticated requests are limited by address
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "10.1.1.3:1000", issuer.token(t)).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(handler, "10.1.1.3:1001", issuer.token(t)).Code)
+}
+
+func TestRateLimiterEvictsIdleKeys(t *testing.T) {
+	rl := newRateLimiter(rate.Every(time.Minute), 1, nil, time.Minute)
+	now := time.Now()
+
+	assert.Equal(t, time.Duration(0), rl.reserve("10.1.1.1", now))
+	assert.True(t, rl.reserve("10.1.1.1", now) > 0)
+	assert.Len(t, rl.limiters, 1)
+
+	later := now.Add(2 * time.Minute)
+	rl.reserve("10.1.1.2", later)
+	assert.Len(t, rl.limiters, 1)
+	assert.Contains(t, rl.limiters, "10.1.1.2")
+}
+
+func TestRateLimitZeroBurstRejects(t *testing.T) {
+	handler := NewRateLimit(rate.Every(time.Minute), 0, nil, 0)(okHandler)
+	rec := serveFrom(handler, "10.1.1.1:1000", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+}
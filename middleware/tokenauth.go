@@ -10,6 +10,11 @@ import (
 	cos "intel/isecl/lib/common/v2/os"
 	ct "intel/isecl/lib/common/v2/types/aas"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"intel/isecl/lib/common/v2/context"
 	clog "intel/isecl/lib/common/v2/log"
@@ -20,37 +25,221 @@ import (
 	"github.com/gorilla/mux"
 )
 
-var jwtVerifier jwtauth.Verifier
-var jwtCertDownloadAttempted bool
+const (
+	// how often the certificate directories are checked for changes
+	certDirCheckInterval time.Duration = 5 * time.Second
+	// bounds of the backoff between attempts to retrieve the jwt signing certificates
+	minCertRetrieveBackoff time.Duration = 5 * time.Second
+	maxCertRetrieveBackoff time.Duration = 5 * time.Minute
+)
+
 var log = clog.GetDefaultLogger()
 var slog = clog.GetSecurityLogger()
 
-func initJwtVerifier(signingCertsDir, trustedCAsDir string, cacheTime time.Duration) error {
+// RetriveJwtCertFn is called when a token is signed by a key that is not trusted yet. It is supposed to save new
+// jwt signing certificates to the signing certificate directory. See NewJwtCertRetriever
+type RetriveJwtCertFn func() error
 
-	certPems, err := cos.GetDirFileContents(signingCertsDir, "*.pem")
+// tokenAuth owns the jwt verifier used by the middleware returned from NewTokenAuth. Requests read the verifier
+// through an atomic value, while reloading the verifier and retrieving certificates is serialized by the mutex.
+type tokenAuth struct {
+	signingCertsDir string
+	trustedCAsDir   string
+	fnGetJwtCerts   RetriveJwtCertFn
+	cacheTime       time.Duration
+	verifyOptions   jwtauth.VerifyOptions
 
-	rootPems, err := cos.GetDirFileContents(trustedCAsDir, "*.pem")
+	verifier      atomic.Value
+	lastDirCheck  int64 // unix nano, accessed atomically
+	mtx           sync.Mutex
+	dirState      string
+	retrieveAfter time.Time
+	backoff       time.Duration
+}
 
-	jwtVerifier, err = jwtauth.NewVerifier(certPems, rootPems, cacheTime)
+// verifierHolder is stored in the atomic value since it requires a consistent concrete type
+type verifierHolder struct {
+	verifier jwtauth.Verifier
+}
 
-	return err
+func newTokenAuth(signingCertsDir, trustedCAsDir string, fnGetJwtCerts RetriveJwtCertFn, cacheTime time.Duration) *tokenAuth {
+	if fnGetJwtCerts == nil {
+		fnGetJwtCerts = func() error {
+			return fmt.Errorf("no function configured to retrieve jwt signing certificates")
+		}
+	}
+	return &tokenAuth{
+		signingCertsDir: signingCertsDir,
+		trustedCAsDir:   trustedCAsDir,
+		fnGetJwtCerts:   fnGetJwtCerts,
+		cacheTime:       cacheTime,
+	}
+}
 
+// getDirState returns a string that changes whenever a pem file is added, removed or modified in the
+// certificate directories
+func (ta *tokenAuth) getDirState() string {
+	return pemDirState(ta.signingCertsDir, ta.trustedCAsDir)
 }
 
-func retrieveAndSaveTrustedJwtSigningCerts() error {
-	if jwtCertDownloadAttempted {
-		return fmt.Errorf("already attempted to download JWT signing certificates. Will not attempt again")
+// pemDirState returns a string that changes whenever a pem file is added, removed or modified in the directories
+func pemDirState(dirs ...string) string {
+	var state strings.Builder
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(fPath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			if matched, _ := path.Match("*.pem", info.Name()); matched {
+				fmt.Fprintf(&state, "%s:%d:%d;", fPath, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
 	}
-	// todo. this function will make https requests and save files
-	// to the directory where we keep trusted certificates
+	return state.String()
+}
 
-	jwtCertDownloadAttempted = true
+func (ta *tokenAuth) current() jwtauth.Verifier {
+	if holder, ok := ta.verifier.Load().(verifierHolder); ok {
+		return holder.verifier
+	}
 	return nil
 }
 
-type RetriveJwtCertFn func() error
+// load creates a new verifier from the certificate directories. Caller should hold the mutex
+func (ta *tokenAuth) load() error {
+	dirState := ta.getDirState()
+
+	certPems, _ := cos.GetDirFileContents(ta.signingCertsDir, "*.pem")
+	rootPems, _ := cos.GetDirFileContents(ta.trustedCAsDir, "*.pem")
+
+	verifier, err := jwtauth.NewVerifier(certPems, rootPems, ta.cacheTime, ta.verifyOptions)
+	if err != nil {
+		return err
+	}
+	ta.verifier.Store(verifierHolder{verifier})
+	ta.dirState = dirState
+	atomic.StoreInt64(&ta.lastDirCheck, time.Now().UnixNano())
+	return nil
+}
+
+// reload replaces the verifier unless it has already been replaced since stale was handed out
+func (ta *tokenAuth) reload(stale jwtauth.Verifier) error {
+	ta.mtx.Lock()
+	defer ta.mtx.Unlock()
+
+	if ta.current() != stale {
+		return nil
+	}
+	return ta.load()
+}
+
+// getVerifier returns the current verifier. It initializes the verifier on first use and reloads it when the
+// certificate directories have changed. Only one request checks the directories in each interval.
+func (ta *tokenAuth) getVerifier() (jwtauth.Verifier, error) {
+	verifier := ta.current()
+	if verifier == nil {
+		if err := ta.reload(nil); err != nil {
+			return nil, err
+		}
+		return ta.current(), nil
+	}
+
+	lastCheck := atomic.LoadInt64(&ta.lastDirCheck)
+	now := time.Now().UnixNano()
+	if now-lastCheck < int64(certDirCheckInterval) || !atomic.CompareAndSwapInt64(&ta.lastDirCheck, lastCheck, now) {
+		return verifier, nil
+	}
+
+	ta.mtx.Lock()
+	defer ta.mtx.Unlock()
+	if ta.getDirState() != ta.dirState {
+		log.Info("jwt signing certificates or trusted CAs changed. reloading jwt verifier")
+		if err := ta.load(); err != nil {
+			log.WithError(err).Error("could not reload jwt verifier. continuing with the current one")
+		}
+	}
+	return ta.current(), nil
+}
+
+// retrieveCerts calls the function that retrieves jwt signing certificates and reloads the verifier. Attempts are
+// spaced out with a backoff that doubles on every failure, so that tokens with unknown key ids cannot make us
+// hammer the certificate source. Returns true if the token should be validated again.
+func (ta *tokenAuth) retrieveCerts(stale jwtauth.Verifier) bool {
+	ta.mtx.Lock()
+	defer ta.mtx.Unlock()
+
+	if ta.current() != stale {
+		return true
+	}
+	now := time.Now()
+	if now.Before(ta.retrieveAfter) {
+		return false
+	}
+
+	if ta.backoff == 0 {
+		ta.backoff = minCertRetrieveBackoff
+	}
+	if err := ta.fnGetJwtCerts(); err != nil {
+		log.WithError(err).Errorf("could not retrieve jwt signing certificates. next attempt in %v", ta.backoff)
+		ta.retrieveAfter = now.Add(ta.backoff)
+		if ta.backoff *= 2; ta.backoff > maxCertRetrieveBackoff {
+			ta.backoff = maxCertRetrieveBackoff
+		}
+		return false
+	}
+	ta.backoff = minCertRetrieveBackoff
+	ta.retrieveAfter = now.Add(minCertRetrieveBackoff)
+
+	if err := ta.load(); err != nil {
+		log.WithError(err).Error("attempt to initialize jwt verifier failed")
+		return false
+	}
+	return true
+}
+
+func (ta *tokenAuth) validateToken(tokenString string, claims *ct.AuthClaims) (*jwtauth.Token, error) {
+
+	verifier, err := ta.getVerifier()
+	if err != nil {
+		return nil, err
+	}
+	token, err := verifier.ValidateTokenAndGetClaims(tokenString, claims)
+
+	// There are two scenarios when we retry the ValidateTokenAndClaims.
+	//     1. The cached verifier has expired - could be because the certificate we are using has just expired
+	//        or the time has reached when we want to look at the CRL list to make sure the certificate is still
+	//        valid.
+	//        Error : VerifierExpiredError
+	//     2. There is no certificate matching the token (maybe all are expired) and we need to call the function
+	//        that retrives a new certificate.
+	switch err.(type) {
+	case *jwtauth.MatchingCertNotFoundError, *jwtauth.MatchingCertJustExpired:
+		if !ta.retrieveCerts(verifier) {
+			return nil, err
+		}
+	case *jwtauth.VerifierExpiredError:
+		if reloadErr := ta.reload(verifier); reloadErr != nil {
+			log.WithError(reloadErr).Error("attempt to initialize jwt verifier failed")
+			return nil, err
+		}
+	default:
+		return token, err
+	}
+	return ta.current().ValidateTokenAndGetClaims(tokenString, claims)
+}
 
-func NewTokenAuth(signingCertsDir, trustedCAsDir string, fnGetJwtCerts RetriveJwtCertFn, cacheTime time.Duration) mux.MiddlewareFunc {
+// NewTokenAuth returns a middleware that only lets requests with a valid bearer token through. Requests that are
+// rejected get a 401 response with a WWW-Authenticate header as described in RFC 6750. An optional
+// TokenAuthOptions sets the realm of the challenge, adds a json error body to the response and sets the claim
+// validation policy of the verifier.
+func NewTokenAuth(signingCertsDir, trustedCAsDir string, fnGetJwtCerts RetriveJwtCertFn, cacheTime time.Duration, options ...TokenAuthOptions) mux.MiddlewareFunc {
+	ta := newTokenAuth(signingCertsDir, trustedCAsDir, fnGetJwtCerts, cacheTime)
+	var opts TokenAuthOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	ta.verifyOptions = opts.VerifyOptions
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -59,58 +248,55 @@ func NewTokenAuth(signingCertsDir, trustedCAsDir string, fnGetJwtCerts RetriveJw
 			splitAuthHeader := strings.Split(r.Header.Get("Authorization"), "Bearer ")
 			if len(splitAuthHeader) <= 1 {
 				log.Error("no bearer token provided for authorization")
-				w.WriteHeader(http.StatusUnauthorized)
+				writeBearerError(w, opts, bearerError{description: "no bearer token provided"})
+				slog.Warningf("%s: Invalid token, requested from %s: ", commLogMsg.AuthenticationFailed, r.RemoteAddr)
+				auditRequest(r, commLogMsg.AuthenticationFailed, "no bearer token provided")
+				return
+			}
+			tokenString := strings.TrimSpace(splitAuthHeader[1])
+			if tokenString == "" {
+				log.Error("empty bearer token provided for authorization")
+				writeBearerError(w, opts, bearerError{bearerInvalidRequest, "the bearer token is empty"})
 				slog.Warningf("%s: Invalid token, requested from %s: ", commLogMsg.AuthenticationFailed, r.RemoteAddr)
+				auditRequest(r, commLogMsg.AuthenticationFailed, "the bearer token is empty")
 				return
 			}
 
 			// the second item in the slice should be the jwtToken. let try to validate
 			claims := ct.AuthClaims{}
-			var err error
-
-			// There are two scenarios when we retry the ValidateTokenAndClaims.
-			//     1. The cached verifier has expired - could be because the certificate we are using has just expired
-			//        or the time has reached when we want to look at the CRL list to make sure the certificate is still
-			//        valid.
-			//        Error : VerifierExpiredError
-			//     2. There are no valid certificates (maybe all are expired) and we need to call the function that retrives
-			//        a new certificate. initJwtVerifier takes care of this scenario.
-
-			for needInit, retryNeeded, looped := jwtVerifier == nil, false, false; retryNeeded || !looped; looped = true {
-
-				if needInit || retryNeeded {
-					if initErr := initJwtVerifier(signingCertsDir, trustedCAsDir, cacheTime); initErr != nil {
-						log.WithError(initErr).Error("attempt to initialize jwt verifier failed")
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-					needInit = false
+			token, err := ta.validateToken(tokenString, &claims)
+			if err != nil {
+				if ta.current() == nil {
+					log.WithError(err).Error("attempt to initialize jwt verifier failed")
+					w.WriteHeader(http.StatusInternalServerError)
+					auditRequest(r, commLogMsg.AppRuntimeErr, "jwt verifier could not be initialized")
+					return
 				}
-				retryNeeded = false
-				_, err = jwtVerifier.ValidateTokenAndGetClaims(strings.TrimSpace(splitAuthHeader[1]), &claims)
-				if err != nil && !looped {
-					switch err.(type) {
-					case *jwtauth.MatchingCertNotFoundError, *jwtauth.MatchingCertJustExpired:
-						fnGetJwtCerts()
-						retryNeeded = true
-					case *jwtauth.VerifierExpiredError:
-						retryNeeded = true
-					}
-
+				bearerErr := classifyTokenError(err)
+				if bearerErr.code == tokenCheckUnavailable {
+					log.WithError(err).Error("token could not be validated")
+					writeBearerError(w, opts, bearerErr)
+					auditRequest(r, commLogMsg.AppRuntimeErr, bearerErr.description)
+					return
 				}
-
-			}
-
-			if err != nil {
 				// this is a validation failure. Let us log the message and return unauthorized
 				log.WithError(err).Error("token validation Failure")
-				w.WriteHeader(http.StatusUnauthorized)
+				writeBearerError(w, opts, bearerErr)
 				slog.Warningf("%s: Invalid token, requested from %s: ", commLogMsg.AuthenticationFailed, r.RemoteAddr)
+				auditRequest(r, commLogMsg.AuthenticationFailed, bearerErr.description)
 				return
 			}
 
-			r = context.SetUserRoles(r, claims.Roles)
-			r = context.SetUserPermissions(r, claims.Permissions)
+			auditSubject(r, token.GetSubject())
+			r = context.SetIdentity(r, &context.Identity{
+				Subject:     token.GetSubject(),
+				Issuer:      token.GetIssuer(),
+				TokenId:     token.GetTokenId(),
+				ExpiresAt:   token.GetExpiresAt(),
+				Roles:       claims.Roles,
+				Permissions: claims.Permissions,
+				AuthMethod:  context.AuthMethodBearerToken,
+			})
 			next.ServeHTTP(w, r)
 		})
 	}
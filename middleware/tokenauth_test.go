@@ -0,0 +1,338 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package middleware
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v2/context"
+	"intel/isecl/lib/common/v2/crypt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	ct "intel/isecl/lib/common/v2/types/aas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testIssuer struct {
+	factory *jwtauth.JwtFactory
+	certPem []byte
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	cert, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("jwt signing", "", "ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	factory, err := jwtauth.NewTokenFactory(pkcs8Der, true, certPem, "AAS JWT Issuer", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIssuer{factory: factory, certPem: certPem}
+}
+
+func (i *testIssuer) token(t *testing.T) string {
+	claims := ct.AuthClaims{Roles: []ct.RoleInfo{{Service: "HVS", Name: "Administrator"}}}
+	token, err := i.factory.Create(&claims, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newCertDirs(t *testing.T) (string, string, func()) {
+	signingCertsDir, err := ioutil.TempDir("", "jwt-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedCAsDir, err := ioutil.TempDir("", "trusted-cas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingCertsDir, trustedCAsDir, func() {
+		os.RemoveAll(signingCertsDir)
+		os.RemoveAll(trustedCAsDir)
+	}
+}
+
+func serveWithToken(handler http.Handler, token string) int {
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestTokenAuthRetrievesMissingCerts(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	var calls int32
+	retrieve := func() error {
+		atomic.AddInt32(&calls, 1)
+		return crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir)
+	}
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, retrieve, time.Hour)(okHandler)
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(handler, ""))
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, issuer.token(t)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, issuer.token(t)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTokenAuthRetrieveBackoff(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	var calls int32
+	retrieve := func() error {
+		atomic.AddInt32(&calls, 1)
+		return fmt.Errorf("certificate source not reachable")
+	}
+	ta := newTokenAuth(signingCertsDir, trustedCAsDir, retrieve, time.Hour)
+	claims := ct.AuthClaims{}
+	token := issuer.token(t)
+	var err error
+
+	// a failed retrieval is not attempted again until the backoff has passed
+	_, err = ta.validateToken(token, &claims)
+	assert.Error(t, err)
+	_, err = ta.validateToken(token, &claims)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, 2*minCertRetrieveBackoff, ta.backoff)
+
+	ta.retrieveAfter = time.Now().Add(-1 * time.Second)
+	_, err = ta.validateToken(token, &claims)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 4*minCertRetrieveBackoff, ta.backoff)
+
+	for i := 0; i < 10; i++ {
+		ta.retrieveAfter = time.Now().Add(-1 * time.Second)
+		ta.validateToken(token, &claims)
+	}
+	assert.Equal(t, maxCertRetrieveBackoff, ta.backoff)
+}
+
+func TestTokenAuthReloadsOnDirChange(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	retrieve := func() error {
+		return fmt.Errorf("certificate source not reachable")
+	}
+	ta := newTokenAuth(signingCertsDir, trustedCAsDir, retrieve, time.Hour)
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, retrieve, time.Hour)(okHandler)
+	claims := ct.AuthClaims{}
+	_, err := ta.validateToken(issuer.token(t), &claims)
+	assert.Error(t, err)
+
+	// the certificate shows up in the directory without the retrieve function being involved
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+	atomic.StoreInt64(&ta.lastDirCheck, time.Now().Add(-1*certDirCheckInterval).UnixNano())
+	_, err = ta.validateToken(issuer.token(t), &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "Administrator", claims.Roles[0].Name)
+
+	// concurrent requests share the instance owned verifier
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serveWithToken(handler, issuer.token(t)))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTokenAuthBearerChallenge(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	unknownIssuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+
+	serve := func(handler http.Handler, authHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/hosts", nil)
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour)(okHandler)
+	rec := serve(handler, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+	assert.Empty(t, rec.Body.String())
+
+	rec = serve(handler, "Bearer not.a.token")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer error="invalid_token", error_description="the token is malformed"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve(handler, "Bearer "+unknownIssuer.token(t))
+	assert.Equal(t, `Bearer error="invalid_token", error_description="the token is signed by an unknown key"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve(handler, "Bearer "+issuer.token(t))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+
+	handler = NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour, TokenAuthOptions{Realm: "HVS", JSONErrorBody: true})(okHandler)
+	rec = serve(handler, "Bearer  ")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, `Bearer realm="HVS", error="invalid_request", error_description="the bearer token is empty"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve(handler, "Bearer not.a.token")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	body := errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, errorResponse{Error: "invalid_token", Message: "the token is malformed"}, body)
+}
+
+func TestClassifyTokenError(t *testing.T) {
+	assert.Equal(t, "the token has expired", classifyTokenError(&jwtauth.TokenExpiredError{}).description)
+	assert.Equal(t, "the token has been revoked", classifyTokenError(&jwtauth.TokenRevokedError{}).description)
+	assert.Equal(t, bearerError{tokenCheckUnavailable, "the revocation status of the token could not be checked"}, classifyTokenError(&jwtauth.RevocationCheckError{}))
+	assert.Equal(t, "the token is signed with an algorithm that is not allowed", classifyTokenError(&jwtauth.AlgorithmNotAllowedError{}).description)
+	assert.Equal(t, "the token is issued by an untrusted issuer", classifyTokenError(&jwtauth.InvalidIssuerError{}).description)
+	assert.Equal(t, "the token is not intended for this service", classifyTokenError(&jwtauth.InvalidAudienceError{}).description)
+	assert.Equal(t, "the token is missing the aud claim", classifyTokenError(&jwtauth.MissingClaimError{Claim: "aud"}).description)
+	assert.Equal(t, "the token lifetime exceeds the allowed maximum", classifyTokenError(&jwtauth.TokenLifetimeExceededError{}).description)
+	assert.Equal(t, "the token is invalid", classifyTokenError(fmt.Errorf("kid missing")).description)
+	assert.Equal(t, bearerInvalidToken, classifyTokenError(&jwtauth.VerifierExpiredError{}).code)
+}
+
+func TestTokenAuthSetsIdentity(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+
+	var identity *context.Identity
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		identity, err = context.IdentityFromContext(r.Context())
+		assert.NoError(t, err)
+	}))
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, issuer.token(t)))
+	assert.Equal(t, "admin", identity.Subject)
+	assert.Equal(t, "AAS JWT Issuer", identity.Issuer)
+	assert.NotEmpty(t, identity.TokenId)
+	assert.True(t, identity.ExpiresAt.After(time.Now()))
+	assert.Equal(t, "Administrator", identity.Roles[0].Name)
+	assert.Equal(t, context.AuthMethodBearerToken, identity.AuthMethod)
+}
+
+func TestTokenAuthVerifyOptions(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	newIssuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+	retrieve := func() error {
+		return crypt.SavePemCertWithShortSha1FileName(newIssuer.certPem, signingCertsDir)
+	}
+	opts := TokenAuthOptions{VerifyOptions: jwtauth.VerifyOptions{Issuers: []string{"CMS"}}}
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, retrieve, time.Hour, opts)(okHandler)
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/hosts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	challenge := `Bearer error="invalid_token", error_description="the token is issued by an untrusted issuer"`
+	rec := serve(issuer.token(t))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, challenge, rec.Header().Get("WWW-Authenticate"))
+
+	// the options still apply after the verifier is reloaded with the retrieved certificate
+	rec = serve(newIssuer.token(t))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, challenge, rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestTokenAuthRejectsRevokedToken(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+	store := jwtauth.NewMemoryRevocationStore()
+	opts := TokenAuthOptions{VerifyOptions: jwtauth.VerifyOptions{RevocationStore: store}}
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour, opts)(okHandler)
+
+	tokenString := issuer.token(t)
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, tokenString))
+
+	token, err := jwtauth.ParseTokenUnverified(tokenString, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Revoke(token.GetTokenId(), token.GetExpiresAt()))
+
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer error="invalid_token", error_description="the token has been revoked"`, rec.Header().Get("WWW-Authenticate"))
+	// other tokens of the same issuer are still accepted
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, issuer.token(t)))
+}
+
+type failingRevocationStore struct {
+	jwtauth.MemoryRevocationStore
+}
+
+func (s *failingRevocationStore) IsRevoked(tokenId string) (bool, error) {
+	return false, fmt.Errorf("revocation store not reachable")
+}
+
+func TestTokenAuthRevocationCheckFailure(t *testing.T) {
+	signingCertsDir, trustedCAsDir, cleanup := newCertDirs(t)
+	defer cleanup()
+
+	issuer := newTestIssuer(t)
+	assert.NoError(t, crypt.SavePemCertWithShortSha1FileName(issuer.certPem, signingCertsDir))
+	opts := TokenAuthOptions{JSONErrorBody: true, VerifyOptions: jwtauth.VerifyOptions{RevocationStore: &failingRevocationStore{}}}
+	handler := NewTokenAuth(signingCertsDir, trustedCAsDir, nil, time.Hour, opts)(okHandler)
+
+	// the token is not known to be bad, so the client is not challenged to get a new one
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	req.Header.Set("Authorization", "Bearer "+issuer.token(t))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+	body := errorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, errorResponse{Error: tokenCheckUnavailable, Message: "the revocation status of the token could not be checked"}, body)
+}
@@ -53,6 +53,10 @@ func GetDirFileContents(dir, pattern string) ([][]byte, error) {
 	}
 
 	err := filepath.Walk(dir, func(fPath string, info os.FileInfo, err error) error {
+		// info is nil when dir cannot be read
+		if err != nil {
+			return err
+		}
 		if info.IsDir() {
 			return nil
 		}
@@ -0,0 +1,37 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package os
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDirFileContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir-contents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0600))
+
+	contents, err := GetDirFileContents(dir, "*.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("ca")}, contents)
+	contents, err = GetDirFileContents(dir, "")
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+	_, err = GetDirFileContents(dir, "*.crt")
+	assert.Error(t, err)
+
+	// a directory that cannot be read is reported as an error instead of panicking
+	_, err = GetDirFileContents(filepath.Join(dir, "no-such-dir"), "*.pem")
+	assert.Error(t, err)
+}
@@ -36,6 +36,13 @@
 		 CertType           string
 		 CaCertsDir         string
 		 BearerToken        string
+		 // BearerTokenFn is called for the token when neither BearerToken nor BEARER_TOKEN are set, for
+		 // instance the Token method of a clients/aas TokenSource
+		 BearerTokenFn      func() (string, error)
+		 // KeyPassword encrypts the private key saved to KeyFile if it is not empty, see
+		 // crypt.SaveEncryptedPrivateKeyAsPKCS8. Such a key has to be loaded with the same password by
+		 // crypt.LoadX509CertAndPrivateKeyWithPassword or crypt.GetPrivateKeyFromPKCS8FileWithPassword
+		 KeyPassword        string
 	     ConsoleWriter      io.Writer
  }
 
@@ -155,6 +162,12 @@
 	    if err == nil {
 			bearerToken = tokenFromEnv
 		}
+		if bearerToken == "" && tc.BearerTokenFn != nil {
+			bearerToken, err = tc.BearerTokenFn()
+			if err != nil {
+				return fmt.Errorf("Certificate setup: could not get bearer token: %v", err)
+			}
+		}
 		if bearerToken == "" {
 			return errors.New("Certificate setup: BEARER_TOKEN not found in environment for Download Certificate")
 		}
@@ -176,7 +189,11 @@
 			if err != nil {
 				return fmt.Errorf("Certificate setup: %v", err)
 			}
-			err = crypt.SavePrivateKeyAsPKCS8(key, tc.KeyFile)
+			if tc.KeyPassword != "" {
+				err = crypt.SaveEncryptedPrivateKeyAsPKCS8(key, tc.KeyFile, []byte(tc.KeyPassword))
+			} else {
+				err = crypt.SavePrivateKeyAsPKCS8(key, tc.KeyFile)
+			}
 			if err != nil {
 				return fmt.Errorf("Certificate setup: %v", err)
 			}
@@ -209,5 +226,10 @@
 	 if os.IsNotExist(err) {
 		 return errors.New("KeyFile is not configured")
 	 }
+	 if tc.KeyPassword != "" {
+		 if _, err = crypt.GetPrivateKeyFromPKCS8FileWithPassword(tc.KeyFile, []byte(tc.KeyPassword)); err != nil {
+			 return fmt.Errorf("KeyFile cannot be loaded with the KeyPassword: %v", err)
+		 }
+	 }
 	 return nil
   }
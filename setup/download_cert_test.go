@@ -0,0 +1,35 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package setup
+
+import (
+	"intel/isecl/lib/common/v2/crypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCertValidateKeyPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, pkcs8Der, err := crypt.CreateKeyPairAndCertificate("test", "", "ecdsa", 384)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, crypt.SaveEncryptedPrivateKeyAsPKCS8(pkcs8Der, keyFile, []byte("password")))
+
+	task := Download_Cert{KeyFile: keyFile, KeyPassword: "password", ConsoleWriter: ioutil.Discard}
+	assert.NoError(t, task.Validate(Context{}))
+	task.KeyPassword = "wrong password"
+	assert.Error(t, task.Validate(Context{}))
+	task.KeyFile = filepath.Join(dir, "missing.pem")
+	assert.Error(t, task.Validate(Context{}))
+}
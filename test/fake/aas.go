@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package fake
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	jwtIssuer     = "AAS JWT Issuer"
+	tokenValidity = time.Hour
+)
+
+// fakeUser is a user known by the fake AAS along with the claims of the tokens issued to it
+type fakeUser struct {
+	password string
+	claims   types.AuthClaims
+}
+
+// AAS is a fake authentication and authorization service. It issues tokens at token for the users added with
+// AddUser and serves its jwt signing certificate at noauth/jwt-certificates, like the real service at /aas/.
+type AAS struct {
+	Server *httptest.Server
+	// BaseURL is the url of the service, i.e. AAS_API_URL
+	BaseURL string
+	// JwtCertUrl is the url the signing certificate is served at, see middleware.NewJwtCertRetriever
+	JwtCertUrl string
+	CA         *CA
+	// SigningCertPem is the jwt signing certificate, issued by CA
+	SigningCertPem []byte
+	Factory        *jwtauth.JwtFactory
+
+	mtx   sync.Mutex
+	users map[string]fakeUser
+}
+
+// NewAAS starts a fake AAS whose TLS and jwt signing certificates are issued by ca
+func NewAAS(ca *CA) (*AAS, error) {
+	signingCertPem, pkcs8Der, err := ca.IssueKeyPair(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "AAS JWT Signing Certificate"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	factory, err := jwtauth.NewTokenFactory(pkcs8Der, true, signingCertPem, jwtIssuer, tokenValidity)
+	if err != nil {
+		return nil, fmt.Errorf("could not create token factory: %v", err)
+	}
+	aas := &AAS{
+		CA:             ca,
+		SigningCertPem: signingCertPem,
+		Factory:        factory,
+		users:          make(map[string]fakeUser),
+	}
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/aas").Subrouter()
+	api.HandleFunc("/token", aas.postToken).Methods("POST")
+	api.HandleFunc("/noauth/jwt-certificates", aas.getJwtCertificates).Methods("GET")
+
+	server, err := startTLSServer(ca, "AAS TLS Certificate", router)
+	if err != nil {
+		return nil, err
+	}
+	aas.Server = server
+	aas.BaseURL = server.URL + "/aas/"
+	aas.JwtCertUrl = aas.BaseURL + "noauth/jwt-certificates"
+	return aas, nil
+}
+
+// Close shuts down the server
+func (aas *AAS) Close() {
+	aas.Server.Close()
+}
+
+// AddUser adds a user that can request tokens with the roles and permissions. Adding an existing user replaces it.
+func (aas *AAS) AddUser(name, password string, roles []types.RoleInfo, permissions []types.PermissionInfo) {
+	aas.mtx.Lock()
+	defer aas.mtx.Unlock()
+	aas.users[name] = fakeUser{
+		password: password,
+		claims:   types.AuthClaims{Roles: roles, Permissions: permissions},
+	}
+}
+
+// Token issues a token for the subject with the claims directly, without going through the server
+func (aas *AAS) Token(subject string, claims types.AuthClaims) (string, error) {
+	return aas.Factory.Create(&claims, subject, 0)
+}
+
+func (aas *AAS) postToken(w http.ResponseWriter, r *http.Request) {
+	var cred types.UserCred
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&cred); err != nil {
+		http.Error(w, "could not decode user credentials", http.StatusBadRequest)
+		return
+	}
+
+	aas.mtx.Lock()
+	user, ok := aas.users[cred.UserName]
+	aas.mtx.Unlock()
+	if !ok || user.password != cred.Password {
+		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := aas.Token(cred.UserName, user.claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Write([]byte(token))
+}
+
+func (aas *AAS) getJwtCertificates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(aas.SigningCertPem)
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+// Package fake provides in-process stand-ins for the certificate management service (CMS) and the
+// authentication and authorization service (AAS), so that setup tasks, clients and middleware can be tested
+// end to end without the real services.
+package fake
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	defaultKeyType   = "ecdsa"
+	defaultKeyLength = 384
+	certValidity     = 24 * time.Hour
+)
+
+// CA is a root certificate authority generated in memory. It issues the TLS certificates of the fake services,
+// the jwt signing certificate of the fake AAS and the certificates requested from the fake CMS.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPem []byte
+	privKey crypto.PrivateKey
+}
+
+// NewCA generates a self signed root CA with the common name
+func NewCA(commonName string) (*CA, error) {
+	certDer, pkcs8Der, err := crypt.CreateKeyPairAndCertificate(commonName, "", defaultKeyType, defaultKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("could not create CA: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA certificate: %v", err)
+	}
+	privKey, err := x509.ParsePKCS8PrivateKey(pkcs8Der)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA private key: %v", err)
+	}
+	return &CA{
+		Cert:    cert,
+		CertPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}),
+		privKey: privKey,
+	}, nil
+}
+
+// SaveCert saves the CA certificate to dir, for instance to the trusted CA directory of a service
+func (ca *CA) SaveCert(dir string) error {
+	return crypt.SavePemCertWithShortSha1FileName(ca.CertPem, dir)
+}
+
+// Issue signs a certificate for pubKey. The serial number and validity of the template are filled in when they
+// are not set. It returns the certificate pem encoded.
+func (ca *CA) Issue(template *x509.Certificate, pubKey crypto.PublicKey) ([]byte, error) {
+	if template.SerialNumber == nil {
+		serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, fmt.Errorf("could not generate serial number: %v", err)
+		}
+		template.SerialNumber = serialNumber
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Minute)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(certValidity)
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, pubKey, ca.privKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not issue certificate for %s: %v", template.Subject, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), nil
+}
+
+// IssueKeyPair generates a key pair and issues a certificate for it. It returns the pem encoded certificate
+// and the PKCS8 der encoded private key.
+func (ca *CA) IssueKeyPair(template *x509.Certificate) ([]byte, []byte, error) {
+	privKey, pubKey, err := crypt.GenerateKeyPair(defaultKeyType, defaultKeyLength)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not generate key pair: %v", err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not marshal private key: %v", err)
+	}
+	certPem, err := ca.Issue(template, pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPem, pkcs8Der, nil
+}
+
+// IssueTLS issues a TLS server certificate for the hosts, which can be host names or IP addresses
+func (ca *CA) IssueTLS(commonName string, hosts ...string) (tls.Certificate, error) {
+	template := x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	certPem, pkcs8Der, err := ca.IssueKeyPair(&template)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Der})
+	return tls.X509KeyPair(certPem, keyPem)
+}
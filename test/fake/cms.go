@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package fake
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v2/crypt"
+	jwtauth "intel/isecl/lib/common/v2/jwt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxRequestSize int64 = 1 << 20
+
+// startTLSServer starts a TLS server for the handler with a certificate for the loopback address issued by ca
+func startTLSServer(ca *CA, commonName string, handler http.Handler) (*httptest.Server, error) {
+	tlsCert, err := ca.IssueTLS(commonName, "127.0.0.1", "::1", "localhost")
+	if err != nil {
+		return nil, err
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+	server.StartTLS()
+	return server, nil
+}
+
+// CMS is a fake certificate management service. It serves the CA certificate at ca-certificates and signs
+// certificate requests posted to certificates?certType=<type>, like the real service at /cms/v1/.
+type CMS struct {
+	Server *httptest.Server
+	// BaseURL is the url the setup tasks are configured with, i.e. CMS_BASE_URL
+	BaseURL string
+	CA      *CA
+	// TLSCertDigest is the SHA384 digest of the TLS certificate of the server in hex, as expected by
+	// setup.DownloadRootCaCertificate
+	TLSCertDigest string
+	// TokenVerifier validates the bearer token of certificate requests. When it is nil any non empty token is
+	// accepted. NewCMS sets it to verify tokens of the AAS it is given.
+	TokenVerifier jwtauth.Verifier
+}
+
+// NewCMS starts a fake CMS whose CA and TLS certificate are issued by ca. When aas is not nil, certificate
+// requests need a token issued by it.
+func NewCMS(ca *CA, aas *AAS) (*CMS, error) {
+	cms := &CMS{CA: ca}
+	if aas != nil {
+		verifier, err := jwtauth.NewVerifier(aas.SigningCertPem, [][]byte{aas.CA.CertPem}, time.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("could not create verifier for AAS tokens: %v", err)
+		}
+		cms.TokenVerifier = verifier
+	}
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/cms/v1").Subrouter()
+	api.HandleFunc("/ca-certificates", cms.getCaCertificates).Methods("GET")
+	api.HandleFunc("/certificates", cms.postCertificates).Methods("POST")
+
+	server, err := startTLSServer(ca, "CMS TLS Certificate", router)
+	if err != nil {
+		return nil, err
+	}
+	cms.Server = server
+	cms.BaseURL = server.URL + "/cms/v1/"
+	cms.TLSCertDigest, err = crypt.GetCertHashInHex(server.Certificate(), crypto.SHA384)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	return cms, nil
+}
+
+// Close shuts down the server
+func (cms *CMS) Close() {
+	cms.Server.Close()
+}
+
+func (cms *CMS) getCaCertificates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(cms.CA.CertPem)
+}
+
+func (cms *CMS) authorize(r *http.Request) error {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return fmt.Errorf("no bearer token provided")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if token == "" {
+		return fmt.Errorf("no bearer token provided")
+	}
+	if cms.TokenVerifier == nil {
+		return nil
+	}
+	_, err := cms.TokenVerifier.ValidateTokenAndGetClaims(token, &map[string]interface{}{})
+	return err
+}
+
+// certTemplate returns the template of the certificate issued for the certificate type. The types are the ones
+// known by the real CMS.
+func certTemplate(certType string, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	template := x509.Certificate{
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}
+	switch strings.ToLower(certType) {
+	case "tls":
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case "tls-client":
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case "signing", "jwt-signing", "flavor-signing":
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	default:
+		return nil, fmt.Errorf("unsupported certificate type %q", certType)
+	}
+	return &template, nil
+}
+
+func (cms *CMS) postCertificates(w http.ResponseWriter, r *http.Request) {
+	if err := cms.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "could not read request body", http.StatusBadRequest)
+		return
+	}
+	// the block type is not checked, setup.GetCertificateFromCMS sends "BEGIN CERTIFICATE REQUEST"
+	block, _ := pem.Decode(body)
+	if block == nil {
+		http.Error(w, "certificate request is not pem encoded", http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		http.Error(w, "could not parse certificate request", http.StatusBadRequest)
+		return
+	}
+	if err = csr.CheckSignature(); err != nil {
+		http.Error(w, "invalid signature of certificate request", http.StatusBadRequest)
+		return
+	}
+
+	template, err := certTemplate(r.URL.Query().Get("certType"), csr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	certPem, err := cms.CA.Issue(template, csr.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(certPem)
+}
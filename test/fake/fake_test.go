@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2019 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package fake
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	aasclient "intel/isecl/lib/common/v2/clients/aas"
+	"intel/isecl/lib/common/v2/crypt"
+	"intel/isecl/lib/common/v2/middleware"
+	cos "intel/isecl/lib/common/v2/os"
+	"intel/isecl/lib/common/v2/setup"
+	types "intel/isecl/lib/common/v2/types/aas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempDir(t *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newServices(t *testing.T) (*CA, *AAS, *CMS) {
+	ca, err := NewCA("Fake Root CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aas, err := NewAAS(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cms, err := NewCMS(ca, aas)
+	if err != nil {
+		aas.Close()
+		t.Fatal(err)
+	}
+	return ca, aas, cms
+}
+
+func TestDownloadCertificates(t *testing.T) {
+	_, aas, cms := newServices(t)
+	defer aas.Close()
+	defer cms.Close()
+	caCertsDir := tempDir(t, "ca-certs")
+	defer os.RemoveAll(caCertsDir)
+
+	// the CA certificate is only saved when the TLS certificate digest matches
+	assert.Error(t, setup.DownloadRootCaCertificate(cms.BaseURL, caCertsDir, "00"))
+	assert.NoError(t, setup.DownloadRootCaCertificate(cms.BaseURL, caCertsDir, cms.TLSCertDigest))
+	caCerts, err := cos.GetDirFileContents(caCertsDir, "*.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{cms.CA.CertPem}, caCerts)
+
+	token, err := aas.Token("installer", types.AuthClaims{})
+	assert.NoError(t, err)
+	subject := pkix.Name{CommonName: "HVS TLS Certificate"}
+	_, _, err = setup.GetCertificateFromCMS("TLS", "ecdsa", 384, cms.BaseURL, subject, "hvs.intel.com", caCertsDir, "not a token")
+	assert.Error(t, err)
+	key, certPem, err := setup.GetCertificateFromCMS("TLS", "ecdsa", 384, cms.BaseURL, subject, "hvs.intel.com", caCertsDir, token)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key)
+
+	cert, err := crypt.GetCertFromPem(certPem)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cms.CA.Cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "hvs.intel.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "HVS TLS Certificate", cert.Subject.CommonName)
+}
+
+func TestTokenAuthWithFakeAas(t *testing.T) {
+	ca, aas, cms := newServices(t)
+	defer aas.Close()
+	defer cms.Close()
+	trustedCAsDir := tempDir(t, "trusted-cas")
+	defer os.RemoveAll(trustedCAsDir)
+	signingCertsDir := tempDir(t, "jwt-certs")
+	defer os.RemoveAll(signingCertsDir)
+	assert.NoError(t, ca.SaveCert(trustedCAsDir))
+
+	roles := []types.RoleInfo{{Service: "HVS", Name: "Administrator"}}
+	aas.AddUser("admin", "password", roles, nil)
+	client, err := aasclient.NewClient(aas.BaseURL, trustedCAsDir, "")
+	assert.NoError(t, err)
+	_, err = client.GetToken(types.UserCred{UserName: "admin", Password: "wrong"})
+	assert.IsType(t, &aasclient.UnauthorizedError{}, err)
+	token, err := client.GetToken(types.UserCred{UserName: "admin", Password: "password"})
+	assert.NoError(t, err)
+
+	// the signing certificate is retrieved from the fake AAS on the first request
+	retriever := middleware.NewJwtCertRetriever(aas.JwtCertUrl, signingCertsDir, trustedCAsDir)
+	handler := middleware.NewTokenAuth(signingCertsDir, trustedCAsDir, retriever, time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	req := httptest.NewRequest("GET", "/hosts", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}